@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// Body of POST /collections and PATCH /collections/:id. A PATCH keeps the
+// fields that are left out, an empty parentId moves the collection to the top level.
+type collectionRequest struct {
+	Name     *string `json:"name"`
+	ParentId *string `json:"parentId"`
+}
+
+// Writes the error response for a failed change to a collection or to the
+// name of a fragment. Returns false when the error isn't one the client caused.
+func writeNameError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, fragment.ErrInvalidName), errors.Is(err, fragment.ErrCollectionCycle):
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	case errors.Is(err, fragment.ErrCollectionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified collection!"})
+	case errors.Is(err, fragment.ErrNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"message": "Something with this name already exists in the collection!"})
+	case errors.Is(err, fragment.ErrCollectionNotEmpty):
+		c.JSON(http.StatusConflict, gin.H{"message": "The collection must be empty to delete it!"})
+	default:
+		return false
+	}
+	return true
+}
+
+// Writes a 500 response for errors that writeNameError doesn't handle.
+func writeCollectionError(c *gin.Context, err error) {
+	if writeNameError(c, err) {
+		return
+	}
+	logger.Sugar.Error("Failed to update the collections: ", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"message": "Server side error"})
+}
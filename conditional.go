@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/gin-gonic/gin"
+)
+
+// Sets the ETag and Last-Modified headers and answers with 304 Not Modified
+// when the request's If-None-Match or If-Modified-Since header shows that the
+// client's copy is still current. Returns true when the response was written.
+func notModified(c *gin.Context, etag string, updated time.Time) bool {
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", updated.UTC().Format(http.TimeFormat))
+
+	// If-Modified-Since is ignored when If-None-Match is present (RFC 9110 13.1.3)
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		if !etagMatches(ifNoneMatch, etag, false) {
+			return false
+		}
+	} else if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err != nil || updated.Truncate(time.Second).After(since) {
+		return false
+	}
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+// Returns the precondition of the request's If-Match header, nil when it has
+// none. It holds while the fragment exists and its entity tag matches the
+// header. The write checks it against the metadata it replaces and only
+// commits while that metadata is unchanged, so two writers holding the same
+// ETag can't both pass it.
+func ifMatch(c *gin.Context) fragment.Precondition {
+	return ifMatchTag(c, (*fragment.Fragment).ETag)
+}
+
+// Like ifMatch, but checks against the entity tag of the fragment's metadata
+// that GET /fragment/:id/info returns.
+func ifMatchInfo(c *gin.Context) fragment.Precondition {
+	return ifMatchTag(c, (*fragment.Fragment).InfoETag)
+}
+
+func ifMatchTag(c *gin.Context, etag func(*fragment.Fragment) string) fragment.Precondition {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return nil
+	}
+	return func(current *fragment.Fragment) bool {
+		return current != nil && current.Deleted == nil && etagMatches(header, etag(current), true)
+	}
+}
+
+// Answers writes whose If-Match precondition failed or that kept losing races
+// against other writes. Returns false for other errors.
+func writeConditionError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, fragment.ErrPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "The fragment has been changed since it was last retrieved!"})
+	case errors.Is(err, fragment.ErrFragmentChanged):
+		c.JSON(http.StatusConflict, gin.H{"message": "The fragment is being changed by other requests, please try again"})
+	default:
+		return false
+	}
+	return true
+}
+
+// Like notModified for a fragment looked up by path. Renaming or moving
+// fragments and collections can point the path at another fragment, even one
+// modified earlier, so only the entity tag, which covers the fragment's id and
+// name, is checked and no Last-Modified is sent.
+func pathNotModified(c *gin.Context, frag *fragment.Fragment) bool {
+	etag := strings.TrimSuffix(frag.InfoETag(), `"`) + "@" + frag.Id + `"`
+	c.Header("ETag", etag)
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag, false) {
+		return false
+	}
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+// Returns the entity tag of the fragment converted to the variant, an extension
+// like .html or a negotiated type like +text/html, which differs from the tag
+// of the fragment's own data.
+func representationETag(frag *fragment.Fragment, variant string) string {
+	etag := frag.ETag()
+	if variant == "" {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + variant + `"`
+}
+
+// Reports whether any entity tag in the comma separated list matches etag.
+// Weak tags never match when strong comparison is used.
+func etagMatches(list string, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong {
+			if !strings.HasPrefix(etag, "W/") && candidate == etag {
+				return true
+			}
+		} else if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
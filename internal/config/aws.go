@@ -0,0 +1,55 @@
+package config
+
+import "os"
+
+// AWSConfig holds the settings shared by the DynamoDB and S3 clients.
+type AWSConfig struct {
+	Region string
+	// Named profile from the shared credentials file, the default credential chain is used when empty
+	Profile string
+	// Custom endpoints, e.g. LocalStack or dynamodb-local. AWS is used when empty
+	S3EndpointURL       string
+	DynamoDBEndpointURL string
+	S3BucketName        string
+	DynamoDBTableName   string
+	// Table holding the version history of fragments
+	DynamoDBVersionsTableName string
+	// Table holding the reference counts of deduplicated blobs
+	DynamoDBBlobsTableName string
+	// Table holding the wrapped data keys used to encrypt fragment data
+	DynamoDBKeysTableName string
+	// Table holding the names of fragments and collections
+	DynamoDBNamesTableName string
+	// Path-style addressing (http://host/bucket/key) is required by LocalStack and MinIO
+	S3UsePathStyle bool
+	// Uploads larger than this many bytes are sent to S3 as multipart uploads
+	// with parts of this size
+	S3MultipartThreshold int64
+}
+
+var AWS AWSConfig
+
+func loadAWSConfig() AWSConfig {
+	cfg := AWSConfig{
+		Region:              getEnvOrDefault("AWS_REGION", "us-east-1"),
+		Profile:             os.Getenv("AWS_PROFILE"),
+		S3EndpointURL:       os.Getenv("AWS_S3_ENDPOINT_URL"),
+		DynamoDBEndpointURL: os.Getenv("AWS_DYNAMODB_ENDPOINT_URL"),
+		// S3_BUCKET is still read for existing deployments
+		S3BucketName:              getEnvOrDefault("AWS_S3_BUCKET_NAME", os.Getenv("S3_BUCKET")),
+		DynamoDBTableName:         getEnvOrDefault("AWS_DYNAMODB_TABLE_NAME", "fragments"),
+		DynamoDBVersionsTableName: getEnvOrDefault("AWS_DYNAMODB_VERSIONS_TABLE_NAME", "fragment-versions"),
+		DynamoDBBlobsTableName:    getEnvOrDefault("AWS_DYNAMODB_BLOBS_TABLE_NAME", "fragment-blobs"),
+		DynamoDBKeysTableName:     getEnvOrDefault("AWS_DYNAMODB_KEYS_TABLE_NAME", "fragment-keys"),
+		DynamoDBNamesTableName:    getEnvOrDefault("AWS_DYNAMODB_NAMES_TABLE_NAME", "fragment-names"),
+		S3MultipartThreshold:      getInt64Env("AWS_S3_MULTIPART_THRESHOLD", "8388608"),
+	}
+
+	// Custom S3 endpoints almost never support virtual hosted buckets
+	pathStyleDefault := "false"
+	if cfg.S3EndpointURL != "" {
+		pathStyleDefault = "true"
+	}
+	cfg.S3UsePathStyle = getEnvOrDefault("AWS_S3_FORCE_PATH_STYLE", pathStyleDefault) == "true"
+	return cfg
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAWSConfig(t *testing.T) {
+	t.Run("TestDefaults", func(t *testing.T) {
+		t.Setenv("AWS_REGION", "")
+		t.Setenv("AWS_S3_ENDPOINT_URL", "")
+		t.Setenv("AWS_S3_BUCKET_NAME", "")
+		t.Setenv("AWS_DYNAMODB_TABLE_NAME", "")
+		t.Setenv("AWS_S3_FORCE_PATH_STYLE", "")
+		t.Setenv("AWS_S3_MULTIPART_THRESHOLD", "")
+		t.Setenv("S3_BUCKET", "legacy-bucket")
+		cfg := loadAWSConfig()
+		assert.Equal(t, "us-east-1", cfg.Region)
+		assert.Equal(t, "legacy-bucket", cfg.S3BucketName)
+		assert.Equal(t, "fragments", cfg.DynamoDBTableName)
+		assert.Equal(t, false, cfg.S3UsePathStyle)
+		assert.Equal(t, int64(8<<20), cfg.S3MultipartThreshold)
+	})
+
+	t.Run("TestLocalEndpoints", func(t *testing.T) {
+		t.Setenv("AWS_REGION", "ca-central-1")
+		t.Setenv("AWS_S3_ENDPOINT_URL", "http://localstack:4566")
+		t.Setenv("AWS_DYNAMODB_ENDPOINT_URL", "http://dynamodb-local:8000")
+		t.Setenv("AWS_S3_BUCKET_NAME", "bucket")
+		t.Setenv("AWS_DYNAMODB_TABLE_NAME", "table")
+		t.Setenv("AWS_S3_FORCE_PATH_STYLE", "")
+		cfg := loadAWSConfig()
+		assert.Equal(t, "ca-central-1", cfg.Region)
+		assert.Equal(t, "http://localstack:4566", cfg.S3EndpointURL)
+		assert.Equal(t, "http://dynamodb-local:8000", cfg.DynamoDBEndpointURL)
+		assert.Equal(t, "bucket", cfg.S3BucketName)
+		assert.Equal(t, "table", cfg.DynamoDBTableName)
+		assert.Equal(t, true, cfg.S3UsePathStyle)
+	})
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"encoding/base64"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Jashanpreet2/fragments/internal/logger"
 	"github.com/joho/godotenv"
@@ -11,6 +14,53 @@ import (
 var LocalCsvAuthentication bool
 var loaded bool
 
+// Names of the backends used to store fragment metadata and data
+var MetadataBackend string
+var DataBackend string
+
+// Database file used by the sqlite metadata backend
+var SQLitePath string
+
+// Settings for the filesystem data backend
+var DataDir string
+var DataFsync bool
+
+// Settings for the background reconciliation of metadata and data. Disabled when the interval is 0
+var ReconcileInterval time.Duration
+var ReconcileDryRun bool
+var ReconcileMinOrphanAge time.Duration
+
+// How long deleted fragments stay in the trash and how often expired ones are purged
+var TrashRetention time.Duration
+var TrashPurgeInterval time.Duration
+
+// Size limits enforced when fragments are written. A limit of 0 disables it
+var MaxFragmentSize int64
+var QuotaBytes int64
+var QuotaFragments int64
+
+// Scope in which identical fragment data is stored once: off, owner or global
+var Dedup string
+
+// Compression applied to newly written fragment data: off, gzip or zstd
+var Compression string
+
+// Master keys that wrap the data keys of encrypted fragment data, by id, and
+// the id of the one new data keys are wrapped with. Encryption is off when no
+// id is set
+var MasterKeys map[string][]byte
+var MasterKeyId string
+
+// Database file of the local full-text search index and how many bytes of each fragment are indexed
+var SearchIndexPath string
+var SearchMaxBytes int64
+
+// Whether the contents of encrypted fragments are indexed, which stores them in the clear
+var SearchEncrypted bool
+
+// Format of new fragment ids: uuidv7 or ulid
+var IDGenerator string
+
 func Config() {
 	if loaded {
 		return
@@ -45,6 +95,41 @@ func Config() {
 		LocalCsvAuthentication = true
 	}
 
+	MetadataBackend = getEnvOrDefault("FRAGMENTS_METADATA_BACKEND", "dynamodb")
+	DataBackend = getEnvOrDefault("FRAGMENTS_DATA_BACKEND", "s3")
+	AWS = loadAWSConfig()
+	SQLitePath = getEnvOrDefault("FRAGMENTS_SQLITE_PATH", "fragments.db")
+	DataDir = getEnvOrDefault("FRAGMENTS_DATA_DIR", "data")
+	DataFsync = getEnvOrDefault("FRAGMENTS_DATA_FSYNC", "true") == "true"
+	ReconcileInterval = getDurationEnv("FRAGMENTS_RECONCILE_INTERVAL", "0")
+	ReconcileDryRun = getEnvOrDefault("FRAGMENTS_RECONCILE_DRY_RUN", "true") == "true"
+	ReconcileMinOrphanAge = getDurationEnv("FRAGMENTS_RECONCILE_MIN_ORPHAN_AGE", "1h")
+	TrashRetention = getDurationEnv("FRAGMENTS_TRASH_RETENTION", "720h")
+	TrashPurgeInterval = getDurationEnv("FRAGMENTS_TRASH_PURGE_INTERVAL", "1h")
+	MaxFragmentSize = getInt64Env("FRAGMENTS_MAX_FRAGMENT_SIZE", "67108864")
+	QuotaBytes = getInt64Env("FRAGMENTS_QUOTA_BYTES", "0")
+	QuotaFragments = getInt64Env("FRAGMENTS_QUOTA_FRAGMENTS", "0")
+	Dedup = getEnvOrDefault("FRAGMENTS_DEDUP", "off")
+	if Dedup != "off" && Dedup != "owner" && Dedup != "global" {
+		logger.Sugar.Fatalf("Invalid value for FRAGMENTS_DEDUP: %s", Dedup)
+	}
+	Compression = getEnvOrDefault("FRAGMENTS_COMPRESSION", "off")
+	if Compression != "off" && Compression != "gzip" && Compression != "zstd" {
+		logger.Sugar.Fatalf("Invalid value for FRAGMENTS_COMPRESSION: %s", Compression)
+	}
+	SearchIndexPath = getEnvOrDefault("FRAGMENTS_SEARCH_INDEX_PATH", "search.db")
+	SearchMaxBytes = getInt64Env("FRAGMENTS_SEARCH_MAX_BYTES", "1048576")
+	SearchEncrypted = getEnvOrDefault("FRAGMENTS_SEARCH_ENCRYPTED", "false") == "true"
+	IDGenerator = getEnvOrDefault("FRAGMENTS_ID_GENERATOR", "uuidv7")
+	if IDGenerator != "uuidv7" && IDGenerator != "ulid" {
+		logger.Sugar.Fatalf("Invalid value for FRAGMENTS_ID_GENERATOR: %s", IDGenerator)
+	}
+	MasterKeys = getMasterKeys("FRAGMENTS_MASTER_KEYS")
+	MasterKeyId = os.Getenv("FRAGMENTS_MASTER_KEY_ID")
+	if _, ok := MasterKeys[MasterKeyId]; MasterKeyId != "" && !ok {
+		logger.Sugar.Fatalf("FRAGMENTS_MASTER_KEY_ID names a key missing from FRAGMENTS_MASTER_KEYS: %s", MasterKeyId)
+	}
+
 	// Check that the necessary environment variables are present
 	if os.Getenv("AWS_COGNITO_POOL_ID") == "" && os.Getenv("AWS_COGNITO_CLIENT_ID") == "" && !LocalCsvAuthentication {
 		logger.Sugar.Fatal("Unable to find AWS_COGNITO_POOL_ID and AWS_COGNITO_CLIENT_ID")
@@ -52,3 +137,44 @@ func Config() {
 
 	loaded = true
 }
+
+func getEnvOrDefault(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getDurationEnv(key string, defaultValue string) time.Duration {
+	duration, err := time.ParseDuration(getEnvOrDefault(key, defaultValue))
+	if err != nil {
+		logger.Sugar.Fatalf("Invalid duration for %s: %s", key, err)
+	}
+	return duration
+}
+
+func getInt64Env(key string, defaultValue string) int64 {
+	value, err := strconv.ParseInt(getEnvOrDefault(key, defaultValue), 10, 64)
+	if err != nil || value < 0 {
+		logger.Sugar.Fatalf("Invalid value for %s: %s", key, getEnvOrDefault(key, defaultValue))
+	}
+	return value
+}
+
+// Parses a comma separated list of <id>:<base64 encoded 32 byte key> entries
+func getMasterKeys(key string) map[string][]byte {
+	keys := map[string][]byte{}
+	value := os.Getenv(key)
+	if value == "" {
+		return keys
+	}
+	for _, entry := range strings.Split(value, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		masterKey, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || id == "" || err != nil || len(masterKey) != 32 {
+			logger.Sugar.Fatalf("Invalid entry in %s, expected <id>:<base64 encoded 32 byte key>", key)
+		}
+		keys[id] = masterKey
+	}
+	return keys
+}
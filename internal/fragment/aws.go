@@ -0,0 +1,20 @@
+package fragment
+
+import (
+	"context"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// Loads the SDK configuration shared by the DynamoDB and S3 clients.
+func loadAWSConfig(cfg config.AWSConfig) (aws.Config, error) {
+	options := []func(*awsConfig.LoadOptions) error{
+		awsConfig.WithRegion(cfg.Region),
+	}
+	if cfg.Profile != "" {
+		options = append(options, awsConfig.WithSharedConfigProfile(cfg.Profile))
+	}
+	return awsConfig.LoadDefaultConfig(context.TODO(), options...)
+}
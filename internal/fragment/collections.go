@@ -0,0 +1,359 @@
+package fragment
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+var ErrNameTaken = errors.New("name is already taken")
+var ErrInvalidName = errors.New("invalid name")
+var ErrCollectionNotFound = errors.New("collection not found")
+var ErrCollectionNotEmpty = errors.New("collection is not empty")
+var ErrCollectionCycle = errors.New("a collection can't be moved into itself")
+
+// Kinds of name entries
+const (
+	EntryFragment   = "fragment"
+	EntryCollection = "collection"
+)
+
+const maxNameLength = 255
+
+// NameEntry names a fragment or a collection within its parent collection.
+// Names are unique within a collection, which makes every path unique per
+// owner. Collections only exist as name entries.
+type NameEntry struct {
+	OwnerId string `dynamodbav:"ownerId"`
+	// Id of the collection holding the entry, empty at the top level
+	ParentId string `dynamodbav:"parentId"`
+	Name     string `dynamodbav:"name"`
+	// EntryFragment or EntryCollection
+	Kind string `dynamodbav:"kind"`
+	// Id of the fragment or collection
+	Id      string    `dynamodbav:"id"`
+	Created time.Time `dynamodbav:"created"`
+}
+
+// Collection groups fragments and other collections under a name, like a folder.
+type Collection struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Id of the collection holding this one, empty at the top level
+	ParentId string `json:"parentId"`
+	// Names of the collection and the collections holding it, e.g. /work/notes
+	Path    string    `json:"path"`
+	Created time.Time `json:"created"`
+}
+
+// Contents of a collection, ordered by name.
+type CollectionListing struct {
+	Collections []Collection
+	FragmentIds []string
+}
+
+// Checks that the name can be used as a path segment.
+func ValidateName(name string) error {
+	if name == "" || len(name) > maxNameLength || name == "." || name == ".." {
+		return fmt.Errorf("%w: names must be 1 to %d characters long", ErrInvalidName, maxNameLength)
+	}
+	if strings.ContainsRune(name, '/') || strings.ContainsFunc(name, unicode.IsControl) {
+		return fmt.Errorf("%w: names can't contain slashes or control characters", ErrInvalidName)
+	}
+	return nil
+}
+
+// The name entries of an owner, with the collections indexed by id
+type nameTree struct {
+	entries     []NameEntry
+	collections map[string]NameEntry
+}
+
+func loadNameTree(store FragmentStore, ownerId string) (*nameTree, error) {
+	entries, err := store.ListNameEntries(ownerId)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, func(a NameEntry, b NameEntry) int { return strings.Compare(a.Name, b.Name) })
+	tree := &nameTree{entries, map[string]NameEntry{}}
+	for _, entry := range entries {
+		if entry.Kind == EntryCollection {
+			tree.collections[entry.Id] = entry
+		}
+	}
+	return tree, nil
+}
+
+// Returns the path of the collection, which is / for the top level.
+func (tree *nameTree) path(collectionId string) string {
+	names := []string{}
+	// The depth is bounded in case concurrent moves created a cycle
+	for id := collectionId; id != "" && len(names) <= len(tree.collections); {
+		entry := tree.collections[id]
+		names = append(names, entry.Name)
+		id = entry.ParentId
+	}
+	slices.Reverse(names)
+	return "/" + strings.Join(names, "/")
+}
+
+func (tree *nameTree) collection(id string) (*Collection, bool) {
+	entry, ok := tree.collections[id]
+	if !ok {
+		return nil, false
+	}
+	return &Collection{entry.Id, entry.Name, entry.ParentId, tree.path(entry.Id), entry.Created}, true
+}
+
+// Reports whether the collection is the other collection or holds it.
+func (tree *nameTree) contains(collectionId string, otherId string) bool {
+	for depth := 0; otherId != "" && depth <= len(tree.collections); depth++ {
+		if otherId == collectionId {
+			return true
+		}
+		otherId = tree.collections[otherId].ParentId
+	}
+	return false
+}
+
+// Returns ErrCollectionNotFound unless the id is empty or names an existing collection.
+func (tree *nameTree) checkParent(parentId string) error {
+	if _, ok := tree.collections[parentId]; parentId != "" && !ok {
+		return ErrCollectionNotFound
+	}
+	return nil
+}
+
+// Creates a collection in the parent collection, or at the top level when
+// parentId is empty.
+func CreateCollection(username string, parentId string, name string) (*Collection, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := loadNameTree(store, username)
+	if err != nil {
+		return nil, err
+	}
+	if err := tree.checkParent(parentId); err != nil {
+		return nil, err
+	}
+	entry := NameEntry{OwnerId: username, ParentId: parentId, Name: name, Kind: EntryCollection, Id: GenerateID(), Created: time.Now()}
+	if err := store.CreateNameEntry(&entry); err != nil {
+		return nil, err
+	}
+	return &Collection{entry.Id, name, parentId, strings.TrimSuffix(tree.path(parentId), "/") + "/" + name, entry.Created}, nil
+}
+
+// Returns every collection of the user, ordered by path.
+func GetCollections(username string) ([]Collection, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := loadNameTree(store, username)
+	if err != nil {
+		return nil, err
+	}
+	collections := []Collection{}
+	for id := range tree.collections {
+		collection, _ := tree.collection(id)
+		collections = append(collections, *collection)
+	}
+	slices.SortFunc(collections, func(a Collection, b Collection) int { return strings.Compare(a.Path, b.Path) })
+	return collections, nil
+}
+
+// Returns nil, nil when the collection doesn't exist.
+func GetCollection(username string, id string) (*Collection, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := loadNameTree(store, username)
+	if err != nil {
+		return nil, err
+	}
+	collection, _ := tree.collection(id)
+	return collection, nil
+}
+
+// Returns the collections and fragments held by the collection, or by the top
+// level when the id is empty. Fragments in the trash are included.
+func ListCollection(username string, id string) (*CollectionListing, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := loadNameTree(store, username)
+	if err != nil {
+		return nil, err
+	}
+	if err := tree.checkParent(id); err != nil {
+		return nil, err
+	}
+	listing := &CollectionListing{Collections: []Collection{}, FragmentIds: []string{}}
+	for _, entry := range tree.entries {
+		if entry.ParentId != id {
+			continue
+		}
+		if entry.Kind == EntryCollection {
+			collection, _ := tree.collection(entry.Id)
+			listing.Collections = append(listing.Collections, *collection)
+		} else {
+			listing.FragmentIds = append(listing.FragmentIds, entry.Id)
+		}
+	}
+	return listing, nil
+}
+
+// Moves the collection into another collection and renames it, which also
+// changes the paths of everything it holds.
+func MoveCollection(username string, id string, parentId string, name string) (*Collection, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := loadNameTree(store, username)
+	if err != nil {
+		return nil, err
+	}
+	current, ok := tree.collections[id]
+	if !ok {
+		return nil, ErrCollectionNotFound
+	}
+	if err := tree.checkParent(parentId); err != nil {
+		return nil, err
+	}
+	if tree.contains(id, parentId) {
+		return nil, ErrCollectionCycle
+	}
+	if current.ParentId != parentId || current.Name != name {
+		moved := current
+		moved.ParentId = parentId
+		moved.Name = name
+		if err := store.CreateNameEntry(&moved); err != nil {
+			return nil, err
+		}
+		if err := store.DeleteNameEntry(username, current.ParentId, current.Name); err != nil {
+			return nil, err
+		}
+		tree.collections[id] = moved
+	}
+	collection, _ := tree.collection(id)
+	return collection, nil
+}
+
+// Deletes an empty collection. Returns false, nil when it doesn't exist and
+// ErrCollectionNotEmpty when it holds anything.
+func DeleteCollection(username string, id string) (bool, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return false, err
+	}
+	tree, err := loadNameTree(store, username)
+	if err != nil {
+		return false, err
+	}
+	entry, ok := tree.collections[id]
+	if !ok {
+		return false, nil
+	}
+	for _, child := range tree.entries {
+		if child.ParentId == id {
+			return false, ErrCollectionNotEmpty
+		}
+	}
+	return true, store.DeleteNameEntry(username, entry.ParentId, entry.Name)
+}
+
+// Returns the fragment at the path, e.g. /work/notes/todo. Returns nil, nil
+// when nothing is at the path, when it is a collection or when the fragment is
+// in the trash.
+func GetFragmentByPath(username string, path string) (*Fragment, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	names := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+	if len(names) == 0 {
+		return nil, nil
+	}
+	parentId := ""
+	for i, name := range names {
+		entry, err := store.GetNameEntry(username, parentId, name)
+		if err != nil || entry == nil {
+			return nil, err
+		}
+		last := i == len(names)-1
+		if last && entry.Kind == EntryFragment {
+			return GetFragment(username, entry.Id)
+		}
+		if last || entry.Kind != EntryCollection {
+			return nil, nil
+		}
+		parentId = entry.Id
+	}
+	return nil, nil
+}
+
+// Claims the name in the collection for the fragment and points the fragment
+// at it without writing it. An empty name takes the fragment out of its
+// collection. The returned function must be called once the fragment was
+// written, or failed to be, and releases whichever entry is no longer used.
+func (frag *Fragment) relocate(store FragmentStore, collectionId string, name string) (func(written bool), error) {
+	previous := *frag
+	if name == frag.Name && collectionId == frag.CollectionId {
+		return func(bool) {}, nil
+	}
+	if name == "" && collectionId != "" {
+		return nil, fmt.Errorf("%w: a fragment needs a name to be in a collection", ErrInvalidName)
+	}
+	if name != "" {
+		if err := ValidateName(name); err != nil {
+			return nil, err
+		}
+		tree, err := loadNameTree(store, frag.OwnerId)
+		if err != nil {
+			return nil, err
+		}
+		if err := tree.checkParent(collectionId); err != nil {
+			return nil, err
+		}
+		entry := NameEntry{OwnerId: frag.OwnerId, ParentId: collectionId, Name: name, Kind: EntryFragment, Id: frag.Id, Created: time.Now()}
+		if err := store.CreateNameEntry(&entry); err != nil {
+			return nil, err
+		}
+	}
+	frag.Name, frag.CollectionId = name, collectionId
+	return func(written bool) {
+		if written {
+			releaseName(store, &previous)
+		} else {
+			releaseName(store, frag)
+		}
+	}, nil
+}
+
+// Removes the fragment's name entry, if it has one. Failures are logged and
+// leave a name that can't be reused until the entry is deleted.
+func releaseName(store FragmentStore, frag *Fragment) {
+	if frag.Name == "" {
+		return
+	}
+	if err := store.DeleteNameEntry(frag.OwnerId, frag.CollectionId, frag.Name); err != nil {
+		logger.Sugar.Warnf("Failed to release the name %s for userid: %s and fragment_id: %s", frag.Name, frag.OwnerId, frag.Id)
+		logger.Sugar.Warn(err)
+	}
+}
@@ -0,0 +1,117 @@
+package fragment
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings of stored fragment data. Data without an encoding is stored as is.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// Returns the encoding new fragment data is stored with, empty when compression is off.
+func storageEncoding() string {
+	if config.Compression == "off" {
+		return ""
+	}
+	return config.Compression
+}
+
+// Suffix of the content keys of deduplicated blobs stored with the encoding,
+// so identical data stored with different encodings never shares a blob.
+func encodingSuffix(encoding string) string {
+	switch encoding {
+	case EncodingGzip:
+		return ".gz"
+	case EncodingZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// Returns a reader over the data compressed with the encoding. The data is
+// compressed while it is read and the reader must be closed so the compression
+// stops when the reader isn't consumed to the end.
+func compressData(data io.Reader, encoding string) io.ReadCloser {
+	if encoding == "" {
+		return io.NopCloser(data)
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		var encoder io.WriteCloser
+		var err error
+		switch encoding {
+		case EncodingGzip:
+			encoder = gzip.NewWriter(writer)
+		case EncodingZstd:
+			encoder, err = zstd.NewWriter(writer)
+		default:
+			err = fmt.Errorf("unknown encoding: %s", encoding)
+		}
+		if err == nil {
+			_, err = io.Copy(encoder, data)
+			if closeErr := encoder.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader
+}
+
+// Wraps a reader over data stored with the encoding so that it returns the
+// original data. Closing the returned reader closes the stored data.
+func decompressData(stored io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "":
+		return stored, nil
+	case EncodingGzip:
+		decoder, err := gzip.NewReader(stored)
+		if err != nil {
+			stored.Close()
+			return nil, err
+		}
+		return decodingReadCloser{decoder, func() { decoder.Close() }, stored}, nil
+	case EncodingZstd:
+		decoder, err := zstd.NewReader(stored, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			stored.Close()
+			return nil, err
+		}
+		return decodingReadCloser{decoder, decoder.Close, stored}, nil
+	}
+	stored.Close()
+	return nil, fmt.Errorf("unknown encoding: %s", encoding)
+}
+
+// Reads decoded data and releases the decoder along with the stored data
+type decodingReadCloser struct {
+	io.Reader
+	release func()
+	stored  io.Closer
+}
+
+func (reader decodingReadCloser) Close() error {
+	reader.release()
+	return reader.stored.Close()
+}
+
+// Returns a reader over length bytes of the decoded data starting at offset.
+// Compressed data can't be read from the middle, so everything before offset
+// is decoded and discarded.
+func decodeRange(decoded io.ReadCloser, offset int64, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, decoded, offset); err != nil {
+		decoded.Close()
+		if err == io.EOF {
+			return nil, fmt.Errorf("offset %d is past the end of the data", offset)
+		}
+		return nil, err
+	}
+	return limitedReadCloser{io.LimitReader(decoded, length), decoded}, nil
+}
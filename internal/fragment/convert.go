@@ -0,0 +1,161 @@
+package fragment
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Jashanpreet2/fragments/internal/utils"
+)
+
+var ErrUnsupportedConversion = errors.New("unsupported conversion")
+
+// Converter turns fragment data of the From type into the To type. From can
+// be a wildcard like text/*, converters for an exact type take precedence.
+type Converter struct {
+	From    string
+	To      string
+	Convert func(data []byte) ([]byte, error)
+}
+
+// Other names of registered types, which fragments may have been stored with
+var typeAliases = map[string]string{
+	"text/md": "text/markdown",
+}
+
+var convertersMu sync.RWMutex
+var converters = []Converter{
+	{From: "text/markdown", To: "text/html", Convert: func(data []byte) ([]byte, error) {
+		return utils.ConvertMdToHtml(data), nil
+	}},
+	{From: "text/markdown", To: "text/plain", Convert: func(data []byte) ([]byte, error) {
+		return utils.ConvertMdToText(data), nil
+	}},
+	{From: "text/html", To: "text/plain", Convert: func(data []byte) ([]byte, error) {
+		return utils.ConvertHtmlToText(data), nil
+	}},
+	{From: "text/plain", To: "text/html", Convert: func(data []byte) ([]byte, error) {
+		return utils.ConvertTextToHtml(data), nil
+	}},
+	// Any other text is already readable as plain text
+	{From: "text/*", To: "text/plain", Convert: func(data []byte) ([]byte, error) {
+		return data, nil
+	}},
+}
+
+func init() {
+	mime.AddExtensionType(".md", "text/markdown")
+	mime.AddExtensionType(".markdown", "text/markdown")
+}
+
+// Adds a conversion, replacing the converter registered for the same types.
+func RegisterConverter(converter Converter) {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	converters = slices.DeleteFunc(converters, func(existing Converter) bool {
+		return existing.From == converter.From && existing.To == converter.To
+	})
+	converters = append(converters, converter)
+}
+
+// Returns the type without parameters and with aliases resolved.
+func canonicalType(mimeType string) string {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	if canonical, ok := typeAliases[mimeType]; ok {
+		return canonical
+	}
+	return mimeType
+}
+
+// Reports whether the type matches a converter's From type.
+func typeMatches(pattern string, mimeType string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mimeType, prefix+"/")
+	}
+	return pattern == mimeType
+}
+
+// Returns the converter from one canonical type to another, preferring exact matches.
+func findConverter(from string, to string) (Converter, bool) {
+	convertersMu.RLock()
+	defer convertersMu.RUnlock()
+	var wildcard *Converter
+	for i, converter := range converters {
+		if converter.To != to || !typeMatches(converter.From, from) {
+			continue
+		}
+		if converter.From == from {
+			return converter, true
+		}
+		if wildcard == nil {
+			wildcard = &converters[i]
+		}
+	}
+	if wildcard != nil {
+		return *wildcard, true
+	}
+	return Converter{}, false
+}
+
+// Converts the fragment data into the type of the extension, e.g. .html.
+// Returns the data and its type.
+func (frag *Fragment) ConvertMimetype(ext string) ([]byte, string, error) {
+	mimeType := mime.TypeByExtension(ext)
+	if mimeType == "" {
+		return nil, "", fmt.Errorf("%w: unknown extension %s", ErrUnsupportedConversion, ext)
+	}
+	return frag.ConvertTo(mimeType)
+}
+
+// Converts the fragment data into the type, which must be one of Formats().
+// Returns the data and its type.
+func (frag *Fragment) ConvertTo(mimeType string) ([]byte, string, error) {
+	from, to := canonicalType(frag.MimeType()), canonicalType(mimeType)
+	var convert func([]byte) ([]byte, error)
+	if from != to {
+		converter, ok := findConverter(from, to)
+		if !ok {
+			return nil, "", fmt.Errorf("%w: %s to %s", ErrUnsupportedConversion, from, to)
+		}
+		convert = converter.Convert
+	}
+	data, err := frag.GetData()
+	if err != nil {
+		return nil, "", err
+	}
+	if convert == nil {
+		return data, frag.MimeType(), nil
+	}
+	converted, err := convert(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return converted, to, nil
+}
+
+// Reports whether the type is the fragment's own type, ignoring parameters and aliases.
+func (frag *Fragment) IsType(mimeType string) bool {
+	return canonicalType(frag.MimeType()) == canonicalType(mimeType)
+}
+
+// Returns the types the fragment can be retrieved as: its own type, the name
+// it is registered under when that differs, and every type it converts to.
+func (frag *Fragment) Formats() []string {
+	own := strings.TrimSpace(strings.Split(frag.MimeType(), ";")[0])
+	from := canonicalType(own)
+	formats := []string{own}
+	if from != own {
+		formats = append(formats, from)
+	}
+	convertersMu.RLock()
+	defer convertersMu.RUnlock()
+	for _, converter := range converters {
+		if typeMatches(converter.From, from) && !slices.Contains(formats, converter.To) {
+			formats = append(formats, converter.To)
+		}
+	}
+	return formats
+}
@@ -0,0 +1,126 @@
+package fragment
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+// Deduplicated blobs are stored under the SHA-256 of their data behind this prefix
+const contentKeyPrefix = "sha256-"
+
+// Owner namespace of the blobs deduplicated across all owners
+const sharedDataOwnerId = "_shared"
+
+// Reference count that marks a blob as being deleted
+const blobDeleting = -1
+
+var ErrBlobDeleting = errors.New("deduplicated blob is being deleted")
+
+// Reports whether the data key names a deduplicated blob. Every fragment
+// version pointing at such a blob holds one reference to it.
+func isContentKey(key string) bool {
+	return strings.HasPrefix(key, contentKeyPrefix)
+}
+
+// Returns the owner namespace and key under which the fragment's data is
+// deduplicated, and false when deduplication is off. Data stored with a
+// different encoding or data key never shares a blob.
+func contentLocation(frag *Fragment) (string, string, bool) {
+	if config.Dedup != "owner" && config.Dedup != "global" {
+		return "", "", false
+	}
+	key := contentKeyPrefix + frag.Hash + encodingSuffix(frag.Encoding)
+	if frag.KeyId != "" {
+		key += ".key-" + frag.KeyId
+	}
+	return dataNamespace(frag.OwnerId), key, true
+}
+
+// Returns the owner namespace that the owner's data is stored in, which
+// decides the data key it is encrypted with.
+func dataNamespace(ownerId string) string {
+	if config.Dedup == "global" {
+		return sharedDataOwnerId
+	}
+	return ownerId
+}
+
+// Takes a reference to the deduplicated blob and makes sure the blob exists,
+// creating it from the freshly written blob at tempKey when it is missing. The
+// writer that took an earlier reference may still be copying the blob, or fail
+// to, so every writer checks. The temporary blob is left for the caller to
+// delete.
+//
+// Returns ErrBlobDeleting without taking a reference when the blob is being
+// deleted.
+func acquireContentBlob(store FragmentStore, ownerId string, tempKey string, dataOwnerId string, key string) error {
+	refs, err := store.AddBlobRefs(dataOwnerId, key, 1)
+	if err != nil {
+		return err
+	}
+	blobs, err := getBlobStore()
+	exists := false
+	if err == nil && refs > 1 {
+		exists, err = blobs.HasFragmentData(dataOwnerId, key)
+	}
+	if err == nil && !exists {
+		// The key is derived from the content, so copying over a blob created in the meantime is harmless
+		err = blobs.CopyFragmentData(ownerId, tempKey, dataOwnerId, key)
+	}
+	if err != nil {
+		if releaseErr := releaseContentBlob(store, dataOwnerId, key); releaseErr != nil {
+			logger.Sugar.Errorf("Failed to release the reference to data key: %s for userid: %s", key, dataOwnerId)
+			logger.Sugar.Error(releaseErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Drops a reference to a deduplicated blob and deletes the blob along with the last one.
+func releaseContentBlob(store FragmentStore, dataOwnerId string, key string) error {
+	refs, err := store.AddBlobRefs(dataOwnerId, key, -1)
+	if err != nil || refs > 0 {
+		return err
+	}
+	blobs, err := getBlobStore()
+	if err != nil {
+		return err
+	}
+	_, err = deleteContentBlob(store, blobs, dataOwnerId, key)
+	return err
+}
+
+// Deletes a deduplicated blob unless it is referenced again. The deletion is
+// claimed first, so no reference can be taken while the blob is deleted.
+// Returns whether the blob was deleted.
+func deleteContentBlob(store FragmentStore, blobs BlobStore, dataOwnerId string, key string) (bool, error) {
+	claimed, err := store.ClaimBlobDeletion(dataOwnerId, key)
+	if err != nil || !claimed {
+		return false, err
+	}
+	err = blobs.DeleteFragmentData(dataOwnerId, key)
+	if endErr := store.EndBlobDeletion(dataOwnerId, key); endErr != nil {
+		// Until the claim is removed, writes of the same data aren't deduplicated
+		logger.Sugar.Errorf("Failed to end the deletion of data key: %s for userid: %s", key, dataOwnerId)
+		logger.Sugar.Error(endErr)
+	}
+	return err == nil, err
+}
+
+// Undoes the data of a write whose metadata couldn't be committed.
+func releaseUncommittedData(store FragmentStore, frag *Fragment) {
+	var err error
+	if isContentKey(frag.dataKey()) {
+		err = releaseContentBlob(store, frag.dataOwnerId(), frag.dataKey())
+	} else {
+		err = DeleteFragmentData(frag.OwnerId, frag.dataKey())
+	}
+	if err != nil {
+		logger.Sugar.Errorf("Failed to remove the data of an uncommitted write for userid: %s and data key: %s", frag.OwnerId, frag.dataKey())
+		logger.Sugar.Error(err)
+	}
+}
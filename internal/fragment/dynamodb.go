@@ -2,12 +2,17 @@ package fragment
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Jashanpreet2/fragments/internal/config"
 	"github.com/Jashanpreet2/fragments/internal/logger"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
@@ -15,6 +20,14 @@ import (
 type FragmentsDynamoDBClient struct {
 	ddbClient *dynamodb.Client
 	TableName string
+	// Keyed by fragmentKey (<ownerId>/<fragmentId>) and version
+	VersionsTableName string
+	// Keyed by ownerId and dataKey, holds the reference counts of deduplicated blobs
+	BlobsTableName string
+	// Keyed by ownerId and id, holds the wrapped data keys
+	KeysTableName string
+	// Keyed by ownerId and entryKey (<parentId>/<name>), holds the names of fragments and collections
+	NamesTableName string
 }
 
 var fragmentsDynamoDBClient *FragmentsDynamoDBClient
@@ -23,18 +36,67 @@ func GetDynamoDBClient() (*FragmentsDynamoDBClient, error) {
 	if fragmentsDynamoDBClient != nil {
 		return fragmentsDynamoDBClient, nil
 	}
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	config.Config()
+	client, err := NewDynamoDBClient(config.AWS)
+	if err != nil {
+		return nil, err
+	}
+	fragmentsDynamoDBClient = client
+	return fragmentsDynamoDBClient, nil
+}
+
+func NewDynamoDBClient(awsCfg config.AWSConfig) (*FragmentsDynamoDBClient, error) {
+	cfg, err := loadAWSConfig(awsCfg)
 	if err != nil {
 		return nil, err
 	}
 	ddbClient := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
-		o.Region = "us-east-1"
+		if awsCfg.DynamoDBEndpointURL != "" {
+			o.BaseEndpoint = aws.String(awsCfg.DynamoDBEndpointURL)
+		}
 	})
-	fragmentsDynamoDBClient = &FragmentsDynamoDBClient{ddbClient, "fragments"}
-	return fragmentsDynamoDBClient, nil
+	return &FragmentsDynamoDBClient{ddbClient, awsCfg.DynamoDBTableName, awsCfg.DynamoDBVersionsTableName, awsCfg.DynamoDBBlobsTableName, awsCfg.DynamoDBKeysTableName,
+		awsCfg.DynamoDBNamesTableName}, nil
 }
 
 func (fragmentsClient *FragmentsDynamoDBClient) WriteFragment(frag *Fragment) error {
+	return fragmentsClient.putFragment(frag, nil, nil)
+}
+
+// Writes the fragment only if there is no item with its key, so that an id
+// collision can never overwrite another fragment.
+func (fragmentsClient *FragmentsDynamoDBClient) CreateFragment(frag *Fragment) error {
+	input := &dynamodb.PutItemInput{ConditionExpression: aws.String("attribute_not_exists(id)")}
+	return fragmentsClient.putFragment(frag, input, ErrFragmentExists)
+}
+
+// Writes the fragment only if the item still has the version and metadata
+// revision of previous. Both are left out of items while they are 0, for
+// fragments written before versioning and through omitempty respectively.
+func (fragmentsClient *FragmentsDynamoDBClient) ReplaceFragment(frag *Fragment, previous *Fragment) error {
+	condition := "attribute_exists(id) AND (#version = :version"
+	if previous.Version == 0 {
+		condition += " OR attribute_not_exists(#version)"
+	}
+	condition += ") AND (metadataRevision = :revision"
+	if previous.MetadataRevision == 0 {
+		condition += " OR attribute_not_exists(metadataRevision)"
+	}
+	condition += ")"
+	input := &dynamodb.PutItemInput{
+		ConditionExpression:      aws.String(condition),
+		ExpressionAttributeNames: map[string]string{"#version": "version"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":version":  &types.AttributeValueMemberN{Value: strconv.Itoa(previous.Version)},
+			":revision": &types.AttributeValueMemberN{Value: strconv.Itoa(previous.MetadataRevision)},
+		},
+	}
+	return fragmentsClient.putFragment(frag, input, ErrFragmentChanged)
+}
+
+// Puts the fragment with the condition of input, if any, and returns
+// conditionErr when the condition fails.
+func (fragmentsClient *FragmentsDynamoDBClient) putFragment(frag *Fragment, input *dynamodb.PutItemInput, conditionErr error) error {
 	logger.Sugar.Info(frag.GetJson())
 	item, err := attributevalue.MarshalMap(frag)
 	var outFrag Fragment
@@ -45,13 +107,21 @@ func (fragmentsClient *FragmentsDynamoDBClient) WriteFragment(frag *Fragment) er
 		return err
 	}
 
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(fragmentsClient.TableName),
-		Item:      item,
+	item["createdNanos"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(frag.Created.UnixNano(), 10)}
+	item["updatedNanos"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(frag.Updated.UnixNano(), 10)}
+
+	if input == nil {
+		input = &dynamodb.PutItemInput{}
 	}
+	input.TableName = aws.String(fragmentsClient.TableName)
+	input.Item = item
 
 	_, err = fragmentsClient.ddbClient.PutItem(context.Background(), input)
 	logger.Sugar.Info(err)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return conditionErr
+	}
 	return err
 }
 
@@ -71,45 +141,347 @@ func (fragmentsClient *FragmentsDynamoDBClient) GetFragment(ownerId string, id s
 
 	var frag Fragment
 	out, err := fragmentsClient.ddbClient.GetItem(context.TODO(), input)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(out.Item) == 0 {
 		return nil, nil
 	}
-	attributevalue.UnmarshalMap(out.Item, &frag)
+	err = attributevalue.UnmarshalMap(out.Item, &frag)
 	return &frag, err
 }
 
-func (fragmentsClient *FragmentsDynamoDBClient) GetFragmentIds(ownerId string) ([]string, error) {
-	out, err := fragmentsClient.ddbClient.Query(context.TODO(), &dynamodb.QueryInput{
-		TableName: aws.String(fragmentsClient.TableName),
-		KeyConditions: map[string]types.Condition{
-			"ownerId": {
-				ComparisonOperator: types.ComparisonOperatorEq,
+// BatchGetItem reads at most this many keys per request
+const dynamoBatchSize = 100
 
-				AttributeValueList: []types.AttributeValue{
-					&types.AttributeValueMemberS{Value: ownerId},
-				},
+// Number of BatchGetItem requests sent at once
+const dynamoBatchConcurrency = 4
+
+// Attempts at reading the keys that DynamoDB returns as unprocessed when it throttles
+const dynamoBatchAttempts = 5
+
+// Reads the fragments with BatchGetItem, splitting them into batches that are
+// sent concurrently.
+func (fragmentsClient *FragmentsDynamoDBClient) BatchGetFragments(ownerId string, ids []string) []FragmentResult {
+	results := make([]FragmentResult, len(ids))
+	semaphore := make(chan struct{}, dynamoBatchConcurrency)
+	var wg sync.WaitGroup
+	for start := 0; start < len(ids); start += dynamoBatchSize {
+		batch := ids[start:min(start+dynamoBatchSize, len(ids))]
+		semaphore <- struct{}{}
+		wg.Add(1)
+		go func(start int, batch []string) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			found, unread, err := fragmentsClient.batchGetFragments(ownerId, batch)
+			for i, id := range batch {
+				results[start+i].Fragment = found[id]
+				if unread[id] {
+					results[start+i].Err = err
+				}
+			}
+		}(start, batch)
+	}
+	wg.Wait()
+	return results
+}
+
+// Reads a single batch, retrying unprocessed keys with exponential backoff.
+// Returns the fragments that were found and, when reading failed, the ids that
+// couldn't be read.
+func (fragmentsClient *FragmentsDynamoDBClient) batchGetFragments(ownerId string, ids []string) (map[string]*Fragment, map[string]bool, error) {
+	found := map[string]*Fragment{}
+	unread := map[string]bool{}
+	keys := []map[string]types.AttributeValue{}
+	for _, id := range ids {
+		// BatchGetItem rejects requests with duplicate keys
+		if !unread[id] {
+			unread[id] = true
+			keys = append(keys, map[string]types.AttributeValue{
+				"ownerId": &types.AttributeValueMemberS{Value: ownerId},
+				"id":      &types.AttributeValueMemberS{Value: id},
+			})
+		}
+	}
+
+	for attempt := 0; len(keys) > 0; attempt++ {
+		if attempt == dynamoBatchAttempts {
+			return found, unread, errors.New("dynamodb left keys unprocessed")
+		}
+		if attempt > 0 {
+			time.Sleep(time.Duration(50<<attempt) * time.Millisecond)
+		}
+		out, err := fragmentsClient.ddbClient.BatchGetItem(context.TODO(), &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{
+				fragmentsClient.TableName: {Keys: keys},
 			},
-		},
+		})
+		if err != nil {
+			return found, unread, err
+		}
+		var items []Fragment
+		if err := attributevalue.UnmarshalListOfMaps(out.Responses[fragmentsClient.TableName], &items); err != nil {
+			return found, unread, err
+		}
+		for i := range items {
+			found[items[i].Id] = &items[i]
+		}
 
+		keys = out.UnprocessedKeys[fragmentsClient.TableName].Keys
+		unread = map[string]bool{}
+		for _, key := range keys {
+			if id, ok := key["id"].(*types.AttributeValueMemberS); ok {
+				unread[id.Value] = true
+			}
+		}
+	}
+	return found, unread, nil
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) GetFragmentIds(ownerId string) ([]string, error) {
+	ids := []string{}
+	// Every page is read, a single Query stops after 1 MB of items
+	paginator := dynamodb.NewQueryPaginator(fragmentsClient.ddbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(fragmentsClient.TableName),
+		KeyConditionExpression: aws.String("ownerId = :ownerId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ownerId": &types.AttributeValueMemberS{Value: ownerId},
+		},
+		// Fragments in the trash are still stored in the table
+		FilterExpression:     aws.String("attribute_not_exists(deleted)"),
 		ProjectionExpression: aws.String("id"),
 	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if id, ok := item["id"].(*types.AttributeValueMemberS); ok {
+				ids = append(ids, id.Value)
+			}
+		}
+	}
+	return ids, nil
+}
 
-	if err != nil {
-		return nil, err
+// Global secondary index of the fragments table that listings sorted by one
+// field are read from. Each is partitioned by ownerId and sorted by a number.
+type dynamoListIndex struct {
+	name      string
+	attribute string
+}
+
+// created and updated are stored as strings that don't sort in time order, so
+// putFragment also stores them as nanoseconds since the epoch for the indexes.
+var dynamoListIndexes = map[string]dynamoListIndex{
+	SortCreated: {"ownerId-created-index", "createdNanos"},
+	SortUpdated: {"ownerId-updated-index", "updatedNanos"},
+	SortSize:    {"ownerId-size-index", "size"},
+}
+
+// Inclusive bounds on a number attribute, nil when there is none.
+type numberRange struct {
+	low  *int64
+	high *int64
+}
+
+func timeRange(after time.Time, before time.Time) numberRange {
+	var bounds numberRange
+	if !after.IsZero() {
+		low := after.UnixNano() + 1
+		bounds.low = &low
+	}
+	if !before.IsZero() {
+		high := before.UnixNano() - 1
+		bounds.high = &high
 	}
+	return bounds
+}
 
-	ids := []string{}
-	for _, item := range out.Items {
-		if id, ok := item["id"].(*types.AttributeValueMemberS); ok {
-			ids = append(ids, id.Value)
+func (bounds numberRange) empty() bool {
+	return bounds.low != nil && bounds.high != nil && *bounds.low > *bounds.high
+}
+
+// Returns the condition that the attribute of the sort field lies within the
+// bounds and adds its names and values, or "" when there are no bounds.
+func (bounds numberRange) condition(field string, names map[string]string, values map[string]types.AttributeValue) string {
+	name := "#" + field
+	var condition string
+	switch {
+	case bounds.low != nil && bounds.high != nil:
+		condition = name + " BETWEEN :" + field + "Low AND :" + field + "High"
+	case bounds.low != nil:
+		condition = name + " >= :" + field + "Low"
+	case bounds.high != nil:
+		condition = name + " <= :" + field + "High"
+	default:
+		return ""
+	}
+	names[name] = dynamoListIndexes[field].attribute
+	if bounds.low != nil {
+		values[":"+field+"Low"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*bounds.low, 10)}
+	}
+	if bounds.high != nil {
+		values[":"+field+"High"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*bounds.high, 10)}
+	}
+	return condition
+}
+
+// Queries the index of the sort field, starting after the cursor. Bounds on the
+// sort field are part of the key condition and the other filters are applied
+// by DynamoDB, then again here since type filters ignore case and parameters.
+// DynamoDB limits the items it reads before filtering them, so the query is
+// continued until the page is full.
+//
+// Fragments with the same sort value are ordered by DynamoDB rather than by id.
+// Fragments written before the created and updated indexes existed are missing
+// from them until they are written again.
+func (fragmentsClient *FragmentsDynamoDBClient) ListFragments(ownerId string, options ListOptions) (*FragmentPage, error) {
+	sort := options.Sort
+	if _, ok := dynamoListIndexes[sort]; !ok {
+		sort = SortCreated
+	}
+	index := dynamoListIndexes[sort]
+
+	sizes := numberRange{}
+	if options.MinSize > 0 {
+		sizes.low = &options.MinSize
+	}
+	if options.MaxSize > 0 {
+		sizes.high = &options.MaxSize
+	}
+	ranges := map[string]numberRange{
+		SortCreated: timeRange(options.CreatedAfter, options.CreatedBefore),
+		SortUpdated: timeRange(options.UpdatedAfter, options.UpdatedBefore),
+		SortSize:    sizes,
+	}
+	// size is a reserved word
+	names := map[string]string{"#size": "size"}
+	values := map[string]types.AttributeValue{
+		":ownerId": &types.AttributeValueMemberS{Value: ownerId},
+	}
+	keyCondition := "ownerId = :ownerId"
+	filters := []string{"attribute_not_exists(deleted)"}
+	for _, field := range []string{SortCreated, SortUpdated, SortSize} {
+		if ranges[field].empty() {
+			return &FragmentPage{Fragments: []Fragment{}}, nil
+		}
+		condition := ranges[field].condition(field, names, values)
+		if condition == "" {
+			continue
+		}
+		// Filters can't use the key attributes of the index
+		if field == sort {
+			keyCondition += " AND " + condition
+		} else {
+			filters = append(filters, condition)
 		}
 	}
+	for i, tag := range options.Tags {
+		placeholder := ":tag" + strconv.Itoa(i)
+		filters = append(filters, "contains(tags, "+placeholder+")")
+		values[placeholder] = &types.AttributeValueMemberS{Value: tag}
+	}
 
-	return ids, nil
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(fragmentsClient.TableName),
+		IndexName:                 aws.String(index.name),
+		KeyConditionExpression:    aws.String(keyCondition),
+		FilterExpression:          aws.String(strings.Join(filters, " AND ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		// Only the attributes used by listings so large items aren't transferred
+		ProjectionExpression: aws.String("ownerId, id, created, updated, fragmentType, #size, tags"),
+		ScanIndexForward:     aws.Bool(!options.Descending),
+	}
+	if options.after != nil {
+		input.ExclusiveStartKey = map[string]types.AttributeValue{
+			"ownerId":       &types.AttributeValueMemberS{Value: ownerId},
+			"id":            &types.AttributeValueMemberS{Value: options.after.Id},
+			index.attribute: &types.AttributeValueMemberN{Value: strconv.FormatInt(options.after.Value, 10)},
+		}
+	}
+
+	fragments := []Fragment{}
+	for {
+		// One more fragment than the limit tells whether there is a next page
+		if options.Limit > 0 {
+			input.Limit = aws.Int32(int32(options.Limit + 1 - len(fragments)))
+		}
+		out, err := fragmentsClient.ddbClient.Query(context.TODO(), input)
+		if err != nil {
+			return nil, err
+		}
+		var items []Fragment
+		if err := attributevalue.UnmarshalListOfMaps(out.Items, &items); err != nil {
+			return nil, err
+		}
+		for i := range items {
+			if options.matches(&items[i]) {
+				fragments = append(fragments, items[i])
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 || options.Limit > 0 && len(fragments) > options.Limit {
+			break
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	return options.page(fragments), nil
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) GetTrashedFragments(ownerId string) ([]Fragment, error) {
+	trashed := []Fragment{}
+	paginator := dynamodb.NewQueryPaginator(fragmentsClient.ddbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(fragmentsClient.TableName),
+		KeyConditionExpression: aws.String("ownerId = :ownerId"),
+		FilterExpression:       aws.String("attribute_exists(deleted)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ownerId": &types.AttributeValueMemberS{Value: ownerId},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		var items []Fragment
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		trashed = append(trashed, items...)
+	}
+	return trashed, nil
+}
+
+// Scans the table for the distinct owners. This reads every item so it is only
+// meant for maintenance jobs.
+func (fragmentsClient *FragmentsDynamoDBClient) GetOwnerIds() ([]string, error) {
+	seen := map[string]bool{}
+	ownerIds := []string{}
+	paginator := dynamodb.NewScanPaginator(fragmentsClient.ddbClient, &dynamodb.ScanInput{
+		TableName:            aws.String(fragmentsClient.TableName),
+		ProjectionExpression: aws.String("ownerId"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if ownerId, ok := item["ownerId"].(*types.AttributeValueMemberS); ok && !seen[ownerId.Value] {
+				seen[ownerId.Value] = true
+				ownerIds = append(ownerIds, ownerId.Value)
+			}
+		}
+	}
+	return ownerIds, nil
 }
 
-func (fragmentsClient *FragmentsDynamoDBClient) deleteFragment(ownerId string, fragmentId string) error {
+func (fragmentsClient *FragmentsDynamoDBClient) DeleteFragment(ownerId string, fragmentId string) error {
 	_, err := fragmentsClient.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
 		TableName: aws.String(fragmentsClient.TableName),
 		Key: map[string]types.AttributeValue{
@@ -123,3 +495,301 @@ func (fragmentsClient *FragmentsDynamoDBClient) deleteFragment(ownerId string, f
 	}
 	return nil
 }
+
+func versionKey(ownerId string, id string, version int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"fragmentKey": &types.AttributeValueMemberS{Value: ownerId + "/" + id},
+		"version":     &types.AttributeValueMemberN{Value: strconv.Itoa(version)},
+	}
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) WriteFragmentVersion(frag *Fragment) error {
+	item, err := attributevalue.MarshalMap(frag)
+	if err != nil {
+		return err
+	}
+	item["fragmentKey"] = &types.AttributeValueMemberS{Value: frag.OwnerId + "/" + frag.Id}
+	_, err = fragmentsClient.ddbClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(fragmentsClient.VersionsTableName),
+		Item:      item,
+		// Versions are immutable, a write that lost the race must not replace the winner's
+		ConditionExpression:      aws.String("attribute_not_exists(#version)"),
+		ExpressionAttributeNames: map[string]string{"#version": "version"},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrVersionExists
+	}
+	return err
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) GetFragmentVersion(ownerId string, id string, version int) (*Fragment, error) {
+	out, err := fragmentsClient.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(fragmentsClient.VersionsTableName),
+		Key:       versionKey(ownerId, id, version),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
+	}
+	var frag Fragment
+	err = attributevalue.UnmarshalMap(out.Item, &frag)
+	return &frag, err
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) GetFragmentVersions(ownerId string, id string) ([]Fragment, error) {
+	versions := []Fragment{}
+	// The version sort key returns the items oldest first
+	paginator := dynamodb.NewQueryPaginator(fragmentsClient.ddbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(fragmentsClient.VersionsTableName),
+		KeyConditionExpression: aws.String("fragmentKey = :fragmentKey"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":fragmentKey": &types.AttributeValueMemberS{Value: ownerId + "/" + id},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		var items []Fragment
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		versions = append(versions, items...)
+	}
+	return versions, nil
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) DeleteFragmentVersion(ownerId string, id string, version int) error {
+	_, err := fragmentsClient.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(fragmentsClient.VersionsTableName),
+		Key:       versionKey(ownerId, id, version),
+	})
+	return err
+}
+
+func blobKey(ownerId string, key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"ownerId": &types.AttributeValueMemberS{Value: ownerId},
+		"dataKey": &types.AttributeValueMemberS{Value: key},
+	}
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) AddBlobRefs(ownerId string, key string, delta int) (int, error) {
+	out, err := fragmentsClient.ddbClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:        aws.String(fragmentsClient.BlobsTableName),
+		Key:              blobKey(ownerId, key),
+		UpdateExpression: aws.String("ADD refs :delta"),
+		// Blobs being deleted can't be referenced
+		ConditionExpression: aws.String("attribute_not_exists(refs) OR refs <> :deleting"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":delta":    &types.AttributeValueMemberN{Value: strconv.Itoa(delta)},
+			":deleting": &types.AttributeValueMemberN{Value: strconv.Itoa(blobDeleting)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return 0, ErrBlobDeleting
+	}
+	if err != nil {
+		return 0, err
+	}
+	var counter struct {
+		Refs int `dynamodbav:"refs"`
+	}
+	if err := attributevalue.UnmarshalMap(out.Attributes, &counter); err != nil {
+		return 0, err
+	}
+	if counter.Refs <= 0 {
+		// Only remove the counter if no reference was added in the meantime
+		_, err = fragmentsClient.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+			TableName:           aws.String(fragmentsClient.BlobsTableName),
+			Key:                 blobKey(ownerId, key),
+			ConditionExpression: aws.String("refs <= :zero"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":zero": &types.AttributeValueMemberN{Value: "0"},
+			},
+		})
+		if err != nil && !errors.As(err, &conditionFailed) {
+			return counter.Refs, err
+		}
+	}
+	return counter.Refs, nil
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) GetBlobRefs(ownerId string, key string) (int, error) {
+	out, err := fragmentsClient.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(fragmentsClient.BlobsTableName),
+		Key:       blobKey(ownerId, key),
+	})
+	if err != nil || len(out.Item) == 0 {
+		return 0, err
+	}
+	var counter struct {
+		Refs int `dynamodbav:"refs"`
+	}
+	err = attributevalue.UnmarshalMap(out.Item, &counter)
+	return max(counter.Refs, 0), err
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) ClaimBlobDeletion(ownerId string, key string) (bool, error) {
+	item := blobKey(ownerId, key)
+	item["refs"] = &types.AttributeValueMemberN{Value: strconv.Itoa(blobDeleting)}
+	_, err := fragmentsClient.ddbClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(fragmentsClient.BlobsTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(dataKey)"),
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) EndBlobDeletion(ownerId string, key string) error {
+	_, err := fragmentsClient.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName:           aws.String(fragmentsClient.BlobsTableName),
+		Key:                 blobKey(ownerId, key),
+		ConditionExpression: aws.String("refs = :deleting"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deleting": &types.AttributeValueMemberN{Value: strconv.Itoa(blobDeleting)},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	return err
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) WriteDataKey(key *DataKey) error {
+	item, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+	_, err = fragmentsClient.ddbClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(fragmentsClient.KeysTableName),
+		Item:      item,
+	})
+	return err
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) GetDataKeys(ownerId string) ([]DataKey, error) {
+	keys := []DataKey{}
+	paginator := dynamodb.NewQueryPaginator(fragmentsClient.ddbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(fragmentsClient.KeysTableName),
+		KeyConditionExpression: aws.String("ownerId = :ownerId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ownerId": &types.AttributeValueMemberS{Value: ownerId},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		var items []DataKey
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		keys = append(keys, items...)
+	}
+	sortDataKeys(keys)
+	return keys, nil
+}
+
+// Scans the whole keys table.
+func (fragmentsClient *FragmentsDynamoDBClient) ListDataKeys() ([]DataKey, error) {
+	keys := []DataKey{}
+	paginator := dynamodb.NewScanPaginator(fragmentsClient.ddbClient, &dynamodb.ScanInput{
+		TableName: aws.String(fragmentsClient.KeysTableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		var items []DataKey
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		keys = append(keys, items...)
+	}
+	return keys, nil
+}
+
+func nameKey(ownerId string, parentId string, name string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"ownerId":  &types.AttributeValueMemberS{Value: ownerId},
+		"entryKey": &types.AttributeValueMemberS{Value: parentId + "/" + name},
+	}
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) CreateNameEntry(entry *NameEntry) error {
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return err
+	}
+	item["entryKey"] = nameKey(entry.OwnerId, entry.ParentId, entry.Name)["entryKey"]
+	_, err = fragmentsClient.ddbClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String(fragmentsClient.NamesTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(entryKey)"),
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrNameTaken
+	}
+	return err
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) GetNameEntry(ownerId string, parentId string, name string) (*NameEntry, error) {
+	out, err := fragmentsClient.ddbClient.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(fragmentsClient.NamesTableName),
+		Key:       nameKey(ownerId, parentId, name),
+	})
+	if err != nil || len(out.Item) == 0 {
+		return nil, err
+	}
+	var entry NameEntry
+	if err := attributevalue.UnmarshalMap(out.Item, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) ListNameEntries(ownerId string) ([]NameEntry, error) {
+	entries := []NameEntry{}
+	paginator := dynamodb.NewQueryPaginator(fragmentsClient.ddbClient, &dynamodb.QueryInput{
+		TableName:              aws.String(fragmentsClient.NamesTableName),
+		KeyConditionExpression: aws.String("ownerId = :ownerId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":ownerId": &types.AttributeValueMemberS{Value: ownerId},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		var items []NameEntry
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, err
+		}
+		entries = append(entries, items...)
+	}
+	return entries, nil
+}
+
+func (fragmentsClient *FragmentsDynamoDBClient) DeleteNameEntry(ownerId string, parentId string, name string) error {
+	_, err := fragmentsClient.ddbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(fragmentsClient.NamesTableName),
+		Key:       nameKey(ownerId, parentId, name),
+	})
+	return err
+}
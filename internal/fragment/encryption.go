@@ -0,0 +1,199 @@
+package fragment
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypted data is split into chunks that are sealed separately with
+// AES-256-GCM so it can be streamed. The stored data starts with a version byte,
+// a random salt and a random nonce prefix. The chunks are sealed with a key
+// derived from the data key and the salt, so nonces only need to be unique per
+// blob rather than across every blob sealed with the data key. Each chunk's
+// nonce is the prefix followed by the chunk's index and a flag marking the last
+// chunk, so chunks can't be reordered, dropped or truncated without failing
+// authentication.
+const (
+	encryptionVersion   byte = 2
+	encryptionChunkSize      = 64 * 1024
+	noncePrefixSize          = 7
+	saltSize                 = 32
+)
+
+var ErrDataAuthentication = errors.New("fragment data failed authentication")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the AEAD for a blob, sealing with a key derived from the data key
+// and the blob's salt.
+func blobAEAD(key []byte, salt []byte) (cipher.AEAD, error) {
+	blobKey := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte("fragments data")), blobKey); err != nil {
+		return nil, err
+	}
+	return newAEAD(blobKey)
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// Reads the next chunk of up to len(chunk) bytes and reports whether it is the
+// last one. A full chunk is only the last one when nothing follows it.
+func readChunk(source *bufio.Reader, chunk []byte) (int, bool, error) {
+	n, err := io.ReadFull(source, chunk)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+	if _, err := source.Peek(1); err == io.EOF {
+		return n, true, nil
+	} else if err != nil {
+		return n, false, err
+	}
+	return n, false, nil
+}
+
+// Returns a reader over the data encrypted with the key. Like compressData the
+// data is encrypted while it is read and the reader must be closed, which also
+// closes data.
+func encryptData(data io.ReadCloser, key []byte) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		defer data.Close()
+		writer.CloseWithError(sealChunks(writer, data, key))
+	}()
+	return reader
+}
+
+func sealChunks(out io.Writer, data io.Reader, key []byte) error {
+	header := make([]byte, 1+saltSize+noncePrefixSize)
+	header[0] = encryptionVersion
+	if _, err := rand.Read(header[1:]); err != nil {
+		return err
+	}
+	salt, prefix := header[1:1+saltSize], header[1+saltSize:]
+	aead, err := blobAEAD(key, salt)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	source := bufio.NewReader(data)
+	chunk := make([]byte, encryptionChunkSize, encryptionChunkSize+aead.Overhead())
+	for index := uint32(0); ; index++ {
+		n, last, err := readChunk(source, chunk[:encryptionChunkSize])
+		if err != nil {
+			return err
+		}
+		sealed := aead.Seal(chunk[:0], chunkNonce(prefix, index, last), chunk[:n], nil)
+		if _, err := out.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Wraps a reader over data encrypted with the key so that it returns the
+// decrypted data. Closing the returned reader closes the stored data.
+func decryptData(stored io.ReadCloser, key []byte) (io.ReadCloser, error) {
+	source := bufio.NewReader(stored)
+	version, err := source.ReadByte()
+	if err != nil {
+		stored.Close()
+		return nil, ErrDataAuthentication
+	}
+	if version != encryptionVersion {
+		stored.Close()
+		return nil, fmt.Errorf("unknown encryption version: %d", version)
+	}
+	header := make([]byte, saltSize+noncePrefixSize)
+	if _, err := io.ReadFull(source, header); err != nil {
+		stored.Close()
+		return nil, ErrDataAuthentication
+	}
+	aead, err := blobAEAD(key, header[:saltSize])
+	if err != nil {
+		stored.Close()
+		return nil, err
+	}
+	return &decryptingReader{
+		aead:   aead,
+		prefix: header[saltSize:],
+		source: source,
+		stored: stored,
+		chunk:  make([]byte, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+type decryptingReader struct {
+	aead   cipher.AEAD
+	prefix []byte
+	source *bufio.Reader
+	stored io.Closer
+	chunk  []byte
+	// Decrypted bytes of the current chunk that haven't been read yet
+	plain []byte
+	index uint32
+	done  bool
+}
+
+func (reader *decryptingReader) Read(p []byte) (int, error) {
+	for len(reader.plain) == 0 {
+		if reader.done {
+			return 0, io.EOF
+		}
+		if err := reader.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, reader.plain)
+	reader.plain = reader.plain[n:]
+	return n, nil
+}
+
+func (reader *decryptingReader) openChunk() error {
+	n, last, err := readChunk(reader.source, reader.chunk)
+	if err != nil {
+		return err
+	}
+	plain, err := reader.aead.Open(reader.chunk[:0], chunkNonce(reader.prefix, reader.index, last), reader.chunk[:n], nil)
+	if err != nil {
+		return ErrDataAuthentication
+	}
+	reader.plain = plain
+	reader.index++
+	reader.done = last
+	return nil
+}
+
+func (reader *decryptingReader) Close() error {
+	return reader.stored.Close()
+}
@@ -0,0 +1,207 @@
+package fragment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileSystemStore stores fragment data on the local disk using the same
+// <ownerId>/<fragmentId> layout as the S3 bucket.
+type FileSystemStore struct {
+	Root string
+	// Flushes files and directories to disk before a write is reported as successful
+	Fsync bool
+}
+
+// Prefix of the temporary files used while writing fragment data
+const tempFilePrefix = ".tmp-"
+
+func NewFileSystemStore(root string, fsync bool) (*FileSystemStore, error) {
+	if root == "" {
+		return nil, errors.New("a root directory is required for the filesystem data backend")
+	}
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+	return &FileSystemStore{Root: root, Fsync: fsync}, nil
+}
+
+// Returns the path of the fragment data, rejecting keys that would escape the root directory.
+func (store *FileSystemStore) path(ownerId string, id string) (string, error) {
+	for _, key := range []string{ownerId, id} {
+		if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, tempFilePrefix) {
+			return "", fmt.Errorf("invalid fragment key: %q", key)
+		}
+	}
+	return filepath.Join(store.Root, ownerId, id), nil
+}
+
+func (store *FileSystemStore) WriteFragmentData(ownerId string, id string, data io.Reader) (int64, error) {
+	path, err := store.path(ownerId, id)
+	if err != nil {
+		return 0, err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return 0, err
+	}
+
+	// Write to a temporary file first and rename it so readers never see a partial write
+	file, err := os.CreateTemp(dir, tempFilePrefix+id+"-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+
+	size, err := io.Copy(file, data)
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	if store.Fsync {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return 0, err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return 0, err
+	}
+	if store.Fsync {
+		if err := syncDir(dir); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+func (store *FileSystemStore) ReadFragmentData(ownerId string, id string) (io.ReadCloser, error) {
+	path, err := store.path(ownerId, id)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrFragmentDataNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (store *FileSystemStore) ReadFragmentDataRange(ownerId string, id string, offset int64, length int64) (io.ReadCloser, error) {
+	reader, err := store.ReadFragmentData(ownerId, id)
+	if err != nil {
+		return nil, err
+	}
+	file := reader.(*os.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return limitedReadCloser{io.LimitReader(file, length), file}, nil
+}
+
+// Reads from a limited part of a file and closes the file
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (store *FileSystemStore) DeleteFragmentData(ownerId string, id string) error {
+	path, err := store.path(ownerId, id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if store.Fsync {
+		return syncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
+func (store *FileSystemStore) HasFragmentData(ownerId string, id string) (bool, error) {
+	path, err := store.path(ownerId, id)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (store *FileSystemStore) CopyFragmentData(srcOwnerId string, srcId string, dstOwnerId string, dstId string) error {
+	reader, err := store.ReadFragmentData(srcOwnerId, srcId)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = store.WriteFragmentData(dstOwnerId, dstId, reader)
+	return err
+}
+
+// Flushes a directory so that renames and deletes inside it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (store *FileSystemStore) ListDataOwnerIds() ([]string, error) {
+	entries, err := os.ReadDir(store.Root)
+	if err != nil {
+		return nil, err
+	}
+	ownerIds := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ownerIds = append(ownerIds, entry.Name())
+		}
+	}
+	return ownerIds, nil
+}
+
+func (store *FileSystemStore) ListFragmentData(ownerId string) ([]BlobInfo, error) {
+	entries, err := os.ReadDir(filepath.Join(store.Root, ownerId))
+	if errors.Is(err, os.ErrNotExist) {
+		return []BlobInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	blobs := []BlobInfo{}
+	for _, entry := range entries {
+		// Skip directories and writes that are still in progress
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, BlobInfo{entry.Name(), info.Size(), info.ModTime()})
+	}
+	return blobs, nil
+}
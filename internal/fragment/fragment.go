@@ -1,17 +1,29 @@
 package fragment
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"mime"
+	"fmt"
+	"io"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/Jashanpreet2/fragments/internal/logger"
-	"github.com/Jashanpreet2/fragments/internal/utils"
 )
 
+var ErrFragmentExists = errors.New("a fragment with this id already exists")
+var ErrFragmentChanged = errors.New("the fragment was changed by another write")
+var ErrPreconditionFailed = errors.New("the fragment doesn't match the precondition")
+
+// Checked against the fragment's current metadata, nil when it doesn't exist,
+// by writes that are only committed while the metadata is unchanged. A write
+// fails with ErrPreconditionFailed when it returns false.
+type Precondition func(current *Fragment) bool
+
 type Fragment struct {
 	Id           string    `json:"id" dynamodbav:"id"`
 	OwnerId      string    `json:"ownerId" dynamodbav:"ownerId"`
@@ -19,6 +31,37 @@ type Fragment struct {
 	Updated      time.Time `json:"updated" dynamodbav:"updated"`
 	FragmentType string    `json:"fragmentType" dynamodbav:"fragmentType"`
 	Size         int       `json:"size" dynamodbav:"size"`
+	// Key of the blob holding the fragment's data. Empty for fragments written
+	// before data keys were introduced, whose data is stored under their id.
+	DataKey string `json:"-" dynamodbav:"dataKey,omitempty"`
+	// Set by the reconciler when the fragment's data is missing
+	Broken bool `json:"broken,omitempty" dynamodbav:"broken,omitempty"`
+	// Incremented on every write, 0 for fragments written before versioning
+	Version int `json:"version" dynamodbav:"version"`
+	// Set while the fragment is in its owner's trash
+	Deleted *time.Time `json:"deleted,omitempty" dynamodbav:"deleted,omitempty"`
+	// Hex encoded SHA-256 of the data. Empty for fragments written before hashes were recorded
+	Hash string `json:"hash,omitempty" dynamodbav:"hash,omitempty"`
+	// Owner namespace of the blob when it isn't the fragment's owner, which is
+	// the case for blobs deduplicated across owners
+	DataOwnerId string `json:"-" dynamodbav:"dataOwnerId,omitempty"`
+	// Compression of the stored data, empty when it is stored as is. Size is
+	// always the size of the original data.
+	Encoding string `json:"encoding,omitempty" dynamodbav:"encoding,omitempty"`
+	// Number of bytes taken up by the stored data, 0 for fragments written before it was recorded
+	StoredSize int `json:"storedSize,omitempty" dynamodbav:"storedSize,omitempty"`
+	// Id of the data key the stored data is encrypted with, empty when it isn't encrypted.
+	// The key belongs to the namespace the data is stored in.
+	KeyId string `json:"keyId,omitempty" dynamodbav:"keyId,omitempty"`
+	// Set by the user, see NormalizeTags and NormalizeMetadata
+	Tags     []string          `json:"tags,omitempty" dynamodbav:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty" dynamodbav:"metadata,omitempty"`
+	// Name of the fragment within its collection, empty when it has none. See NameEntry
+	Name string `json:"name,omitempty" dynamodbav:"name,omitempty"`
+	// Collection holding the fragment, empty at the top level
+	CollectionId string `json:"collectionId,omitempty" dynamodbav:"collectionId,omitempty"`
+	// Incremented whenever the tags, metadata, name, collection or trash state change without a new version
+	MetadataRevision int `json:"metadataRevision,omitempty" dynamodbav:"metadataRevision,omitempty"`
 }
 
 func (frag *Fragment) GetJson() (string, bool) {
@@ -29,72 +72,265 @@ func (frag *Fragment) GetJson() (string, bool) {
 	return string(jsonData), true
 }
 
+// Reads the whole fragment data into memory. Use OpenData to stream it instead.
 func (frag *Fragment) GetData() ([]byte, error) {
-	file, err := ReadFragmentData(frag.OwnerId, frag.Id)
+	reader, err := frag.OpenData()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Returns a reader over the fragment data, which the caller must close.
+func (frag *Fragment) OpenData() (io.ReadCloser, error) {
+	reader, err := ReadFragmentData(frag.dataOwnerId(), frag.dataKey())
+	if err == nil && frag.KeyId != "" {
+		var key []byte
+		if key, err = unwrapDataKey(frag.dataOwnerId(), frag.KeyId); err != nil {
+			reader.Close()
+		} else {
+			reader, err = decryptData(reader, key)
+		}
+	}
+	if err == nil {
+		reader, err = decompressData(reader, frag.Encoding)
+	}
 	if err != nil {
 		logger.Sugar.Errorf("Failed to find data for the current fragment at userid: %s and fragment_id: %s", frag.OwnerId, frag.Id)
 		logger.Sugar.Error(err)
 		return nil, err
 	}
-	return file, nil
+	return reader, nil
+}
+
+// Returns a reader over length bytes of the fragment data starting at offset.
+// Only the requested bytes are read unless the data is compressed or encrypted.
+func (frag *Fragment) OpenDataRange(offset int64, length int64) (io.ReadCloser, error) {
+	if frag.Encoding != "" || frag.KeyId != "" {
+		reader, err := frag.OpenData()
+		if err != nil {
+			return nil, err
+		}
+		return decodeRange(reader, offset, length)
+	}
+	reader, err := ReadFragmentDataRange(frag.dataOwnerId(), frag.dataKey(), offset, length)
+	if err != nil {
+		logger.Sugar.Errorf("Failed to read a range of the data for userid: %s and fragment_id: %s", frag.OwnerId, frag.Id)
+		logger.Sugar.Error(err)
+		return nil, err
+	}
+	return reader, nil
 }
 
-// TODO: FIX THIS
 func (frag *Fragment) SetData(data []byte) error {
-	frag.Updated = time.Now()
-	if err := WriteFragment(frag); err != nil {
+	return frag.WriteData(bytes.NewReader(data))
+}
+
+// Streams the data into a new version of the fragment and updates the
+// fragment's metadata to match it. Created, the name and the collection are
+// kept from the previous version, and so are the tags and custom metadata
+// unless they are set on frag.
+//
+// The data is written to a new blob first and the metadata is only pointed at
+// it once the upload succeeded, so readers either see the previous data or the
+// new data, never metadata without its data. If the metadata can't be written
+// the new blob is deleted again. Blobs of previous versions are kept.
+//
+// Concurrent writes never overwrite each other's versions: a write that loses
+// the race is committed again on top of the one that won, even when both
+// created the fragment.
+//
+// When deduplication is on the new blob is only temporary: the data is stored
+// under its SHA-256 unless a blob with the same content already exists.
+//
+// The data is compressed with the configured encoding and encrypted with the
+// data key of the namespace it ends up in on its way to the blob store. Size
+// and Hash describe the original data.
+func (frag *Fragment) WriteData(data io.Reader) error {
+	return frag.writeData(data, false, nil)
+}
+
+// Like WriteData but only creates new fragments. Returns ErrFragmentExists when
+// the owner already has a fragment with the id.
+func (frag *Fragment) CreateData(data io.Reader) error {
+	return frag.writeData(data, true, nil)
+}
+
+// Like WriteData but returns ErrPreconditionFailed unless the precondition
+// holds for the metadata the new version is committed on top of.
+func (frag *Fragment) WriteDataIf(data io.Reader, precondition Precondition) error {
+	return frag.writeData(data, false, precondition)
+}
+
+func (frag *Fragment) writeData(data io.Reader, create bool, precondition Precondition) error {
+	previous, err := ReadFragment(frag.OwnerId, frag.Id)
+	if err != nil {
+		return err
+	}
+	if err := checkPrevious(previous, create, precondition); err != nil {
+		return err
+	}
+
+	keyId, key, err := currentDataKey(dataNamespace(frag.OwnerId))
+	if err != nil {
+		return err
+	}
+	dataKey := newDataKey(frag.Id)
+	hash := sha256.New()
+	var tee io.Writer = hash
+	text := newSearchText(frag.FragmentType, keyId != "")
+	if text != nil {
+		tee = io.MultiWriter(hash, text)
+	}
+	original := &countingReader{reader: io.TeeReader(data, tee)}
+	encoding := storageEncoding()
+	stored := compressData(original, encoding)
+	if key != nil {
+		stored = encryptData(stored, key)
+	}
+	storedSize, err := WriteFragmentData(frag.OwnerId, dataKey, stored)
+	stored.Close()
+	if err != nil {
+		return err
+	}
+
+	committed := *frag
+	committed.DataKey = dataKey
+	committed.DataOwnerId = ""
+	committed.Size = int(original.count)
+	committed.StoredSize = int(storedSize)
+	committed.Encoding = encoding
+	committed.KeyId = keyId
+	committed.Hash = hex.EncodeToString(hash.Sum(nil))
+	committed.Updated = time.Now()
+	committed.Broken = false
+
+	store, err := getFragmentStore()
+	if err != nil {
 		return err
 	}
-	if err := WriteFragmentData(frag.OwnerId, frag.Id, data); err != nil {
+	if dataOwnerId, key, ok := contentLocation(&committed); ok {
+		err := acquireContentBlob(store, frag.OwnerId, dataKey, dataOwnerId, key)
+		if errors.Is(err, ErrBlobDeleting) {
+			// The data stays under its own key rather than waiting for the deletion
+			logger.Sugar.Infof("Not deduplicating data key: %s for userid: %s while it is deleted", key, dataOwnerId)
+		} else {
+			// The data now lives under its content key, or the write failed
+			if deleteErr := DeleteFragmentData(frag.OwnerId, dataKey); deleteErr != nil {
+				logger.Sugar.Warnf("Failed to remove the temporary data for userid: %s and data key: %s", frag.OwnerId, dataKey)
+			}
+			if err != nil {
+				return err
+			}
+			committed.DataKey = key
+			if dataOwnerId != frag.OwnerId {
+				committed.DataOwnerId = dataOwnerId
+			}
+		}
+	}
+
+	written, err := commitNext(frag.OwnerId, frag.Id, previous, func(previous *Fragment) (*Fragment, error) {
+		if err := checkPrevious(previous, create, precondition); err != nil {
+			return nil, err
+		}
+		next := committed
+		return &next, nil
+	})
+	if err != nil {
+		releaseUncommittedData(store, &committed)
 		return err
 	}
+	updateSearchIndex(written, text)
+	*frag = *written
 	return nil
 }
 
-func (frag *Fragment) Save() error {
-	return WriteFragment(frag)
+// Checks that the data can be written on top of the fragment's current metadata.
+func checkPrevious(previous *Fragment, create bool, precondition Precondition) error {
+	if precondition != nil && !precondition(previous) {
+		return ErrPreconditionFailed
+	}
+	if create && previous != nil {
+		return ErrFragmentExists
+	}
+	if previous != nil && previous.Deleted != nil {
+		return ErrFragmentInTrash
+	}
+	return nil
 }
 
-func (frag *Fragment) MimeType() string {
-	return frag.FragmentType
+// Returns the entity tag of the fragment's data. Fragments without a recorded
+// hash get a weak tag derived from their blob, which changes on every write.
+func (frag *Fragment) ETag() string {
+	if frag.Hash == "" {
+		return `W/"` + frag.dataKey() + `"`
+	}
+	return `"` + frag.Hash + `"`
 }
 
-func (frag *Fragment) ConvertMimetype(ext string) ([]byte, string, error) {
-	data, err := frag.GetData()
-	mime.AddExtensionType(".md", "text/markdown")
-	mime.AddExtensionType(".markdown", "text/markdown")
-	mimeType := strings.Split(mime.TypeByExtension(ext), ";")[0]
-	if mimeType == "" {
-		return nil, "", errors.New("extension doesn't exist")
-	}
-	if err != nil {
-		return nil, "", errors.New("unable to retrieve data")
+// Returns the entity tag of the fragment's metadata, which changes with its
+// data, with every version and with every change to its tags, metadata, name
+// or collection.
+func (frag *Fragment) InfoETag() string {
+	return fmt.Sprintf(`%s.v%d.m%d"`, strings.TrimSuffix(frag.ETag(), `"`), frag.Version, frag.MetadataRevision)
+}
+
+func (frag *Fragment) dataKey() string {
+	if frag.DataKey == "" {
+		return frag.Id
 	}
-	if frag.MimeType() == "text/markdown" {
-		logger.Sugar.Info(mimeType)
-		if mimeType == "text/html" {
-			return utils.ConvertMdToHtml(data), "text/markdown", nil
-		}
+	return frag.DataKey
+}
+
+// Returns the number of bytes taken up by the stored data, which is the size
+// of the data itself when it is stored as is.
+func (frag *Fragment) storedSize() int {
+	if frag.storedAsIs() {
+		return frag.Size
 	}
-	return nil, "", errors.New("unsupported extension")
+	return frag.StoredSize
 }
 
-func (frag *Fragment) Formats() []string {
-	mimeType := frag.MimeType()
-	if mimeType == "text/md" || mimeType == "text/markdown" {
-		return []string{"text/md", "text/markdown", "text/html"}
+// Reports whether the data is stored neither compressed nor encrypted.
+func (frag *Fragment) storedAsIs() bool {
+	return frag.Encoding == "" && frag.KeyId == ""
+}
+
+func (frag *Fragment) dataOwnerId() string {
+	if frag.DataOwnerId == "" {
+		return frag.OwnerId
 	}
-	return []string{mimeType}
+	return frag.DataOwnerId
+}
+
+// Returns a blob key that is unique to a single write of the fragment's data
+func newDataKey(id string) string {
+	return id + "@" + GenerateID()
+}
+
+func (frag *Fragment) Save() error {
+	return WriteFragment(frag)
+}
+
+func (frag *Fragment) MimeType() string {
+	return frag.FragmentType
 }
 
 func GetUserFragmentIds(username string) ([]string, error) {
 	return ListFragmentIDs(username)
 }
 
+// Returns nil, nil when the fragment doesn't exist or is in the trash.
 func GetFragment(username string, fragment_id string) (*Fragment, error) {
-	return ReadFragment(username, fragment_id)
+	frag, err := ReadFragment(username, fragment_id)
+	if err != nil || frag == nil || frag.Deleted != nil {
+		return nil, err
+	}
+	return frag, nil
 }
 
+// Permanently deletes the fragment. Use TrashFragment for deletes that can be undone.
 func DeleteFragment(username string, fragment_id string) bool {
 	return DeleteFragmentDB(username, fragment_id)
 }
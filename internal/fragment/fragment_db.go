@@ -0,0 +1,155 @@
+package fragment
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+func WriteFragment(frag *Fragment) error {
+	store, err := getFragmentStore()
+	if err != nil {
+		return err
+	}
+	return store.WriteFragment(frag)
+}
+
+func ReadFragment(userid string, fragment_id string) (*Fragment, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetFragment(userid, fragment_id)
+}
+
+// Reads the metadata of several of the user's fragments at once. Returns one
+// result per id in the same order. Results without a fragment or an error are
+// fragments that don't exist or are in the trash.
+func GetFragments(username string, ids []string) []FragmentResult {
+	store, err := getFragmentStore()
+	if err != nil {
+		results := make([]FragmentResult, len(ids))
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+	results := store.BatchGetFragments(username, ids)
+	for i := range results {
+		if results[i].Fragment != nil && results[i].Fragment.Deleted != nil {
+			results[i].Fragment = nil
+		}
+	}
+	return results
+}
+
+// Stores the data read from the reader and returns the number of bytes written.
+func WriteFragmentData(userid string, fragment_id string, data io.Reader) (int64, error) {
+	store, err := getBlobStore()
+	if err != nil {
+		return 0, err
+	}
+	return store.WriteFragmentData(userid, fragment_id, data)
+}
+
+// Returns a reader over the fragment data, which the caller must close.
+func ReadFragmentData(userid string, fragment_id string) (io.ReadCloser, error) {
+	store, err := getBlobStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ReadFragmentData(userid, fragment_id)
+}
+
+// Returns a reader over part of the fragment data, which the caller must close.
+func ReadFragmentDataRange(userid string, fragment_id string, offset int64, length int64) (io.ReadCloser, error) {
+	store, err := getBlobStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ReadFragmentDataRange(userid, fragment_id, offset, length)
+}
+
+func DeleteFragmentData(userid string, dataKey string) error {
+	store, err := getBlobStore()
+	if err != nil {
+		return err
+	}
+	return store.DeleteFragmentData(userid, dataKey)
+}
+
+// Deletes the fragment metadata, its versions and all of their data from the databases.
+//
+// The metadata goes first so a failure can never leave a fragment pointing at
+// deleted data. If removing the data fails afterwards the fragment is still
+// deleted and only unreferenced blobs remain.
+func DeleteFragmentDB(userid string, fragment_id string) bool {
+	metadataStore, err := getFragmentStore()
+	if err != nil {
+		logger.Sugar.Error(err)
+		return false
+	}
+	frag, err := metadataStore.GetFragment(userid, fragment_id)
+	if err != nil {
+		logger.Sugar.Error(err)
+		return false
+	}
+	versions, err := metadataStore.GetFragmentVersions(userid, fragment_id)
+	if err != nil {
+		logger.Sugar.Error(err)
+		return false
+	}
+	dataKeys := []string{fragment_id}
+	if frag != nil {
+		if dataKeys, err = fragmentDataKeys(metadataStore, frag); err != nil {
+			logger.Sugar.Error(err)
+			return false
+		}
+	}
+
+	err = metadataStore.DeleteFragment(userid, fragment_id)
+	if err != nil {
+		return false
+	}
+	removeFromSearchIndex(userid, fragment_id)
+	if frag != nil {
+		releaseName(metadataStore, frag)
+	}
+	for _, version := range versions {
+		if err := metadataStore.DeleteFragmentVersion(userid, fragment_id, version.Version); err != nil {
+			logger.Sugar.Warnf("Failed to delete version %d for userid: %s and fragment_id: %s", version.Version, userid, fragment_id)
+			logger.Sugar.Warn(err)
+			continue
+		}
+		if isContentKey(version.dataKey()) {
+			if err := releaseContentBlob(metadataStore, version.dataOwnerId(), version.dataKey()); err != nil {
+				logger.Sugar.Warnf("Failed to release data key: %s of userid: %s and fragment_id: %s", version.dataKey(), userid, fragment_id)
+				logger.Sugar.Warn(err)
+			}
+		}
+	}
+	for _, dataKey := range dataKeys {
+		if err := DeleteFragmentData(userid, dataKey); err != nil {
+			logger.Sugar.Warn(fmt.Sprintf("Deleted the fragment metadata but failed to delete the "+
+				"fragment data for userid: %s and fragment_id: %s", userid, fragment_id))
+			logger.Sugar.Warn(err)
+		}
+	}
+	return true
+}
+
+func ListFragmentIDs(userid string) ([]string, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetFragmentIds(userid)
+}
+
+// Drops the current stores and search index so that they are recreated from
+// the configuration. Resets all data when using the in-memory backends.
+func ResetDB() {
+	SetStores(nil, nil)
+	SetSearchIndex(nil)
+}
@@ -0,0 +1,75 @@
+package fragment
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/google/uuid"
+)
+
+// IDGenerator creates the ids of new fragments, collections and data keys.
+// Ids must be unique per owner and shouldn't be guessable.
+type IDGenerator interface {
+	NewID() string
+}
+
+// UUIDv7Generator creates version 7 UUIDs, which start with a millisecond
+// timestamp and sort in creation order.
+type UUIDv7Generator struct{}
+
+func (UUIDv7Generator) NewID() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
+
+// ULIDGenerator creates ULIDs: a 48 bit millisecond timestamp followed by 80
+// random bits, written as 26 characters of Crockford's base32.
+type ULIDGenerator struct{}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (ULIDGenerator) NewID() string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(id[6:]); err != nil {
+		panic(err)
+	}
+	// The 128 bits are written 5 at a time starting with the lowest, the first character holds the top 3
+	high, low := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	encoded := make([]byte, 26)
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = crockfordBase32[low&31]
+		low = low>>5 | high<<59
+		high >>= 5
+	}
+	return string(encoded)
+}
+
+var idGeneratorMu sync.RWMutex
+var idGenerator IDGenerator
+
+// Replaces the generator of new ids. When it is nil the generator named in the
+// configuration is used.
+func SetIDGenerator(generator IDGenerator) {
+	idGeneratorMu.Lock()
+	defer idGeneratorMu.Unlock()
+	idGenerator = generator
+}
+
+func getIDGenerator() IDGenerator {
+	idGeneratorMu.RLock()
+	defer idGeneratorMu.RUnlock()
+	if idGenerator != nil {
+		return idGenerator
+	}
+	if config.IDGenerator == "ulid" {
+		return ULIDGenerator{}
+	}
+	return UUIDv7Generator{}
+}
+
+func GenerateID() string {
+	return getIDGenerator().NewID()
+}
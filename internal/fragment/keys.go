@@ -0,0 +1,272 @@
+package fragment
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+var ErrUnknownMasterKey = errors.New("unknown master key")
+var ErrDataKeyNotFound = errors.New("data key not found")
+
+// KeyProvider wraps data keys with master keys that never leave the provider,
+// e.g. a KMS. Master keys are identified by an id that is stored next to every
+// wrapped key.
+type KeyProvider interface {
+	// Returns the id of the master key that new data keys are wrapped with.
+	CurrentKeyId() string
+	// The context is authenticated along with the key and must be passed
+	// unchanged to UnwrapKey.
+	WrapKey(masterKeyId string, key []byte, context []byte) ([]byte, error)
+	UnwrapKey(masterKeyId string, wrapped []byte, context []byte) ([]byte, error)
+}
+
+// DataKey is an AES-256 key that encrypts the data in one owner namespace,
+// stored wrapped by a master key.
+type DataKey struct {
+	OwnerId     string    `dynamodbav:"ownerId"`
+	Id          string    `dynamodbav:"id"`
+	WrappedKey  []byte    `dynamodbav:"wrappedKey"`
+	MasterKeyId string    `dynamodbav:"masterKeyId"`
+	Created     time.Time `dynamodbav:"created"`
+}
+
+// StaticKeyProvider wraps data keys with AES-256-GCM master keys held in memory.
+type StaticKeyProvider struct {
+	keys    map[string][]byte
+	current string
+}
+
+func NewStaticKeyProvider(keys map[string][]byte, current string) (*StaticKeyProvider, error) {
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key %s must be 32 bytes long", id)
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, current)
+	}
+	return &StaticKeyProvider{keys, current}, nil
+}
+
+func (provider *StaticKeyProvider) CurrentKeyId() string {
+	return provider.current
+}
+
+func (provider *StaticKeyProvider) WrapKey(masterKeyId string, key []byte, context []byte) ([]byte, error) {
+	aead, err := provider.aead(masterKeyId)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, context), nil
+}
+
+func (provider *StaticKeyProvider) UnwrapKey(masterKeyId string, wrapped []byte, context []byte) ([]byte, error) {
+	aead, err := provider.aead(masterKeyId)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], context)
+}
+
+func (provider *StaticKeyProvider) aead(masterKeyId string) (cipher.AEAD, error) {
+	key, ok := provider.keys[masterKeyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, masterKeyId)
+	}
+	return newAEAD(key)
+}
+
+var keyProviderMu sync.RWMutex
+var keyProvider KeyProvider
+
+// Unwrapped data keys by <ownerId>/<keyId> and the id of each owner's newest data key
+var dataKeyCache sync.Map
+var currentDataKeyIds sync.Map
+
+// Replaces the provider used to wrap data keys. When it is nil the master keys
+// from the configuration are used, and data isn't encrypted when there are none.
+func SetKeyProvider(provider KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	keyProvider = provider
+	clearDataKeyCache()
+}
+
+// Returns nil, nil when encryption is off.
+func getKeyProvider() (KeyProvider, error) {
+	keyProviderMu.RLock()
+	provider := keyProvider
+	keyProviderMu.RUnlock()
+	if provider != nil || config.MasterKeyId == "" {
+		return provider, nil
+	}
+
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	if keyProvider == nil {
+		static, err := NewStaticKeyProvider(config.MasterKeys, config.MasterKeyId)
+		if err != nil {
+			return nil, err
+		}
+		keyProvider = static
+	}
+	return keyProvider, nil
+}
+
+func clearDataKeyCache() {
+	dataKeyCache.Clear()
+	currentDataKeyIds.Clear()
+}
+
+// Binds a wrapped key to the namespace and id it was created for
+func wrapContext(ownerId string, keyId string) []byte {
+	return []byte(ownerId + "/" + keyId)
+}
+
+// Returns the id and the unwrapped data key that new data in the owner
+// namespace is encrypted with, creating the key on first use. Returns empty
+// values when encryption is off.
+//
+// Two writers creating the first key of a namespace at once each create one,
+// which is harmless since every fragment records the key it was encrypted with.
+func currentDataKey(ownerId string) (string, []byte, error) {
+	provider, err := getKeyProvider()
+	if err != nil || provider == nil {
+		return "", nil, err
+	}
+	if keyId, ok := currentDataKeyIds.Load(ownerId); ok {
+		key, err := unwrapDataKey(ownerId, keyId.(string))
+		return keyId.(string), key, err
+	}
+
+	store, err := getFragmentStore()
+	if err != nil {
+		return "", nil, err
+	}
+	keys, err := store.GetDataKeys(ownerId)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(keys) > 0 {
+		newest := keys[len(keys)-1]
+		key, err := unwrapDataKey(ownerId, newest.Id)
+		if err == nil {
+			currentDataKeyIds.Store(ownerId, newest.Id)
+		}
+		return newest.Id, key, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, err
+	}
+	dataKey := DataKey{OwnerId: ownerId, Id: GenerateID(), MasterKeyId: provider.CurrentKeyId(), Created: time.Now()}
+	if dataKey.WrappedKey, err = provider.WrapKey(dataKey.MasterKeyId, key, wrapContext(ownerId, dataKey.Id)); err != nil {
+		return "", nil, err
+	}
+	if err := store.WriteDataKey(&dataKey); err != nil {
+		return "", nil, err
+	}
+	logger.Sugar.Infof("Created data key %s for userid: %s", dataKey.Id, ownerId)
+	dataKeyCache.Store(ownerId+"/"+dataKey.Id, key)
+	currentDataKeyIds.Store(ownerId, dataKey.Id)
+	return dataKey.Id, key, nil
+}
+
+// Returns the unwrapped data key of the owner namespace with the id.
+func unwrapDataKey(ownerId string, keyId string) ([]byte, error) {
+	if key, ok := dataKeyCache.Load(ownerId + "/" + keyId); ok {
+		return key.([]byte), nil
+	}
+	provider, err := getKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		return nil, errors.New("the fragment data is encrypted but no master keys are configured")
+	}
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := store.GetDataKeys(ownerId)
+	if err != nil {
+		return nil, err
+	}
+	for _, dataKey := range keys {
+		if dataKey.Id != keyId {
+			continue
+		}
+		key, err := provider.UnwrapKey(dataKey.MasterKeyId, dataKey.WrappedKey, wrapContext(ownerId, keyId))
+		if err != nil {
+			return nil, err
+		}
+		dataKeyCache.Store(ownerId+"/"+keyId, key)
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: %s for userid: %s", ErrDataKeyNotFound, keyId, ownerId)
+}
+
+// Wraps every data key that isn't wrapped by the current master key with it
+// and returns how many were rewrapped. The data keys themselves stay the same,
+// so no fragment data is rewritten. Once it succeeded the previous master keys
+// are no longer needed.
+func RotateDataKeys() (int, error) {
+	provider, err := getKeyProvider()
+	if err != nil {
+		return 0, err
+	}
+	if provider == nil {
+		return 0, errors.New("no master keys are configured")
+	}
+	store, err := getFragmentStore()
+	if err != nil {
+		return 0, err
+	}
+	keys, err := store.ListDataKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	current := provider.CurrentKeyId()
+	rotated := 0
+	for _, dataKey := range keys {
+		if dataKey.MasterKeyId == current {
+			continue
+		}
+		context := wrapContext(dataKey.OwnerId, dataKey.Id)
+		key, err := provider.UnwrapKey(dataKey.MasterKeyId, dataKey.WrappedKey, context)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to unwrap data key %s for userid %s: %w", dataKey.Id, dataKey.OwnerId, err)
+		}
+		if dataKey.WrappedKey, err = provider.WrapKey(current, key, context); err != nil {
+			return rotated, err
+		}
+		dataKey.MasterKeyId = current
+		if err := store.WriteDataKey(&dataKey); err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
+// Orders data keys from oldest to newest
+func sortDataKeys(keys []DataKey) {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+}
@@ -0,0 +1,180 @@
+package fragment
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Fields that listings can be sorted by
+const (
+	SortCreated = "created"
+	SortUpdated = "updated"
+	SortSize    = "size"
+)
+
+// Filters, order and page of a fragment listing. The zero value lists every
+// fragment sorted by creation time, oldest first.
+type ListOptions struct {
+	// Maximum number of fragments on a page, every fragment when 0
+	Limit int
+	// Cursor returned with the previous page, empty for the first page
+	Cursor string
+	// Only fragments of this type, parameters like the charset are ignored
+	Type string
+	// Only fragments with every one of these tags
+	Tags []string
+	// Bounds on the creation and update times, both exclusive. Zero times are ignored
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	// Bounds on the size in bytes, both inclusive. A MaxSize of 0 is ignored
+	MinSize int64
+	MaxSize int64
+	// One of SortCreated, SortUpdated or SortSize, SortCreated when empty
+	Sort       string
+	Descending bool
+
+	// Decoded Cursor, set by ListFragments before the options are handed to the store
+	after *listCursor
+}
+
+// A page of a fragment listing.
+type FragmentPage struct {
+	Fragments []Fragment
+	// Cursor of the next page, empty on the last page
+	NextCursor string
+}
+
+// Position in a listing, encoded into the opaque cursor handed to clients.
+// The sort and order are included so that a cursor can't be used with a
+// listing it doesn't belong to.
+type listCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      int64  `json:"v"`
+	Id         string `json:"i"`
+}
+
+// Lists the user's fragments that match the options, one page at a time.
+//
+// Pages are based on the sort value and id of the last fragment on the
+// previous page, so fragments that are created or deleted between requests
+// don't shift the following pages. A fragment whose sort value changes between
+// requests can be skipped or listed twice.
+//
+// Only the fields set by FragmentStore.ListFragments are set on the returned fragments.
+func ListFragments(username string, options ListOptions) (*FragmentPage, error) {
+	if options.Sort == "" {
+		options.Sort = SortCreated
+	}
+	if !slices.Contains([]string{SortCreated, SortUpdated, SortSize}, options.Sort) {
+		return nil, fmt.Errorf("unknown sort field: %s", options.Sort)
+	}
+	if options.Cursor != "" {
+		cursor, err := decodeCursor(options.Cursor)
+		if err != nil || cursor.Sort != options.Sort || cursor.Descending != options.Descending {
+			return nil, ErrInvalidCursor
+		}
+		options.after = cursor
+	}
+
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListFragments(username, options)
+}
+
+// Reports whether the fragment comes after the cursor, if any.
+func (options *ListOptions) isAfter(frag *Fragment) bool {
+	return options.after == nil || options.compare(sortValue(frag, options.Sort), frag.Id, options.after.Value, options.after.Id) > 0
+}
+
+// Returns the first page of the sorted fragments, which hold at least one
+// fragment more than the limit when there is a next page.
+func (options *ListOptions) page(fragments []Fragment) *FragmentPage {
+	page := &FragmentPage{Fragments: fragments}
+	if options.Limit > 0 && len(page.Fragments) > options.Limit {
+		page.Fragments = page.Fragments[:options.Limit]
+		last := page.Fragments[len(page.Fragments)-1]
+		page.NextCursor = encodeCursor(listCursor{options.Sort, options.Descending, sortValue(&last, options.Sort), last.Id})
+	}
+	return page
+}
+
+func (options *ListOptions) matches(frag *Fragment) bool {
+	if options.Type != "" && !strings.EqualFold(strings.TrimSpace(strings.Split(frag.FragmentType, ";")[0]), options.Type) {
+		return false
+	}
+	if !frag.HasTags(options.Tags) {
+		return false
+	}
+	if !options.CreatedAfter.IsZero() && !frag.Created.After(options.CreatedAfter) {
+		return false
+	}
+	if !options.CreatedBefore.IsZero() && !frag.Created.Before(options.CreatedBefore) {
+		return false
+	}
+	if !options.UpdatedAfter.IsZero() && !frag.Updated.After(options.UpdatedAfter) {
+		return false
+	}
+	if !options.UpdatedBefore.IsZero() && !frag.Updated.Before(options.UpdatedBefore) {
+		return false
+	}
+	if int64(frag.Size) < options.MinSize {
+		return false
+	}
+	return options.MaxSize <= 0 || int64(frag.Size) <= options.MaxSize
+}
+
+// Orders by the sort value and then by id, so the order is total
+func (options *ListOptions) compare(value int64, id string, otherValue int64, otherId string) int {
+	order := 0
+	if value != otherValue {
+		order = -1
+		if value > otherValue {
+			order = 1
+		}
+	} else {
+		order = strings.Compare(id, otherId)
+	}
+	if options.Descending {
+		return -order
+	}
+	return order
+}
+
+func sortValue(frag *Fragment, sort string) int64 {
+	switch sort {
+	case SortUpdated:
+		return frag.Updated.UnixNano()
+	case SortSize:
+		return int64(frag.Size)
+	}
+	return frag.Created.UnixNano()
+}
+
+func encodeCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,406 @@
+package fragment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/memorydb"
+)
+
+// MemoryStore keeps fragment metadata and data in memory. It is meant for local
+// development and tests, everything is lost when the server stops.
+type MemoryStore struct {
+	fragmentDB memorydb.LocalDB
+	dataDB     memorydb.LocalDB
+	// Partitioned by <ownerId>/<fragmentId> and sorted by version number
+	versionDB memorydb.LocalDB
+	// Reference counts of deduplicated blobs keyed by <ownerId>/<dataKey>
+	refsMu   sync.Mutex
+	blobRefs map[string]int
+	keyDB    memorydb.LocalDB
+	// Serializes fragment writes so that conditional writes can check and put atomically
+	writeMu sync.Mutex
+	// Serializes version writes so that only one of them can claim a version
+	versionMu sync.Mutex
+	// Name entries keyed by <ownerId>/<parentId>/<name>
+	namesMu sync.Mutex
+	names   map[string]NameEntry
+}
+
+var ErrFragmentDataNotFound = errors.New("fragment data not found")
+
+type memoryBlob struct {
+	data         []byte
+	lastModified time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (store *MemoryStore) WriteFragment(frag *Fragment) error {
+	store.writeMu.Lock()
+	defer store.writeMu.Unlock()
+	store.fragmentDB.Put(frag.OwnerId, frag.Id, *frag)
+	return nil
+}
+
+func (store *MemoryStore) CreateFragment(frag *Fragment) error {
+	store.writeMu.Lock()
+	defer store.writeMu.Unlock()
+	if _, ok := store.fragmentDB.GetValue(frag.OwnerId, frag.Id); ok {
+		return ErrFragmentExists
+	}
+	store.fragmentDB.Put(frag.OwnerId, frag.Id, *frag)
+	return nil
+}
+
+func (store *MemoryStore) ReplaceFragment(frag *Fragment, previous *Fragment) error {
+	store.writeMu.Lock()
+	defer store.writeMu.Unlock()
+	value, ok := store.fragmentDB.GetValue(frag.OwnerId, frag.Id)
+	if !ok {
+		return ErrFragmentChanged
+	}
+	if current, ok := value.(Fragment); !ok || current.Version != previous.Version || current.MetadataRevision != previous.MetadataRevision {
+		return ErrFragmentChanged
+	}
+	store.fragmentDB.Put(frag.OwnerId, frag.Id, *frag)
+	return nil
+}
+
+func (store *MemoryStore) GetFragment(ownerId string, id string) (*Fragment, error) {
+	value, ok := store.fragmentDB.GetValue(ownerId, id)
+	if !ok {
+		return nil, nil
+	}
+	frag, ok := value.(Fragment)
+	if !ok {
+		return nil, fmt.Errorf("value stored is not a fragment for userid: %s and fragment_id: %s", ownerId, id)
+	}
+	return &frag, nil
+}
+
+func (store *MemoryStore) BatchGetFragments(ownerId string, ids []string) []FragmentResult {
+	results := make([]FragmentResult, len(ids))
+	for i, id := range ids {
+		results[i].Fragment, results[i].Err = store.GetFragment(ownerId, id)
+	}
+	return results
+}
+
+func (store *MemoryStore) GetFragmentIds(ownerId string) ([]string, error) {
+	ids := []string{}
+	for _, frag := range store.ownerFragments(ownerId) {
+		if frag.Deleted == nil {
+			ids = append(ids, frag.Id)
+		}
+	}
+	return ids, nil
+}
+
+func (store *MemoryStore) ListFragments(ownerId string, options ListOptions) (*FragmentPage, error) {
+	fragments := []Fragment{}
+	for _, frag := range store.ownerFragments(ownerId) {
+		if frag.Deleted == nil && options.matches(&frag) && options.isAfter(&frag) {
+			fragments = append(fragments, frag)
+		}
+	}
+	slices.SortFunc(fragments, func(a Fragment, b Fragment) int {
+		return options.compare(sortValue(&a, options.Sort), a.Id, sortValue(&b, options.Sort), b.Id)
+	})
+	return options.page(fragments), nil
+}
+
+func (store *MemoryStore) GetTrashedFragments(ownerId string) ([]Fragment, error) {
+	trashed := []Fragment{}
+	for _, frag := range store.ownerFragments(ownerId) {
+		if frag.Deleted != nil {
+			trashed = append(trashed, frag)
+		}
+	}
+	return trashed, nil
+}
+
+func (store *MemoryStore) ownerFragments(ownerId string) []Fragment {
+	fragments := []Fragment{}
+	for _, id := range store.fragmentDB.GetSKs(ownerId) {
+		if value, ok := store.fragmentDB.GetValue(ownerId, id); ok {
+			if frag, ok := value.(Fragment); ok {
+				fragments = append(fragments, frag)
+			}
+		}
+	}
+	return fragments
+}
+
+func (store *MemoryStore) DeleteFragment(ownerId string, id string) error {
+	store.writeMu.Lock()
+	defer store.writeMu.Unlock()
+	store.fragmentDB.DeleteValue(ownerId, id)
+	return nil
+}
+
+func (store *MemoryStore) GetOwnerIds() ([]string, error) {
+	return store.fragmentDB.GetPKs(), nil
+}
+
+func (store *MemoryStore) WriteFragmentVersion(frag *Fragment) error {
+	store.versionMu.Lock()
+	defer store.versionMu.Unlock()
+	if _, ok := store.versionDB.GetValue(frag.OwnerId+"/"+frag.Id, strconv.Itoa(frag.Version)); ok {
+		return ErrVersionExists
+	}
+	store.versionDB.Put(frag.OwnerId+"/"+frag.Id, strconv.Itoa(frag.Version), *frag)
+	return nil
+}
+
+func (store *MemoryStore) GetFragmentVersion(ownerId string, id string, version int) (*Fragment, error) {
+	value, ok := store.versionDB.GetValue(ownerId+"/"+id, strconv.Itoa(version))
+	if !ok {
+		return nil, nil
+	}
+	frag, ok := value.(Fragment)
+	if !ok {
+		return nil, fmt.Errorf("value stored is not a fragment version for userid: %s and fragment_id: %s", ownerId, id)
+	}
+	return &frag, nil
+}
+
+func (store *MemoryStore) GetFragmentVersions(ownerId string, id string) ([]Fragment, error) {
+	versions := []Fragment{}
+	for _, sk := range store.versionDB.GetSKs(ownerId + "/" + id) {
+		if value, ok := store.versionDB.GetValue(ownerId+"/"+id, sk); ok {
+			if frag, ok := value.(Fragment); ok {
+				versions = append(versions, frag)
+			}
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+func (store *MemoryStore) DeleteFragmentVersion(ownerId string, id string, version int) error {
+	store.versionDB.DeleteValue(ownerId+"/"+id, strconv.Itoa(version))
+	return nil
+}
+
+func (store *MemoryStore) AddBlobRefs(ownerId string, key string, delta int) (int, error) {
+	store.refsMu.Lock()
+	defer store.refsMu.Unlock()
+	if store.blobRefs == nil {
+		store.blobRefs = map[string]int{}
+	}
+	if store.blobRefs[ownerId+"/"+key] == blobDeleting {
+		return 0, ErrBlobDeleting
+	}
+	refs := store.blobRefs[ownerId+"/"+key] + delta
+	if refs <= 0 {
+		delete(store.blobRefs, ownerId+"/"+key)
+	} else {
+		store.blobRefs[ownerId+"/"+key] = refs
+	}
+	return refs, nil
+}
+
+func (store *MemoryStore) GetBlobRefs(ownerId string, key string) (int, error) {
+	store.refsMu.Lock()
+	defer store.refsMu.Unlock()
+	return max(store.blobRefs[ownerId+"/"+key], 0), nil
+}
+
+func (store *MemoryStore) ClaimBlobDeletion(ownerId string, key string) (bool, error) {
+	store.refsMu.Lock()
+	defer store.refsMu.Unlock()
+	if _, ok := store.blobRefs[ownerId+"/"+key]; ok {
+		return false, nil
+	}
+	if store.blobRefs == nil {
+		store.blobRefs = map[string]int{}
+	}
+	store.blobRefs[ownerId+"/"+key] = blobDeleting
+	return true, nil
+}
+
+func (store *MemoryStore) EndBlobDeletion(ownerId string, key string) error {
+	store.refsMu.Lock()
+	defer store.refsMu.Unlock()
+	if store.blobRefs[ownerId+"/"+key] == blobDeleting {
+		delete(store.blobRefs, ownerId+"/"+key)
+	}
+	return nil
+}
+
+func (store *MemoryStore) WriteDataKey(key *DataKey) error {
+	store.keyDB.Put(key.OwnerId, key.Id, *key)
+	return nil
+}
+
+func (store *MemoryStore) GetDataKeys(ownerId string) ([]DataKey, error) {
+	keys := []DataKey{}
+	for _, sk := range store.keyDB.GetSKs(ownerId) {
+		if value, ok := store.keyDB.GetValue(ownerId, sk); ok {
+			if key, ok := value.(DataKey); ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sortDataKeys(keys)
+	return keys, nil
+}
+
+func (store *MemoryStore) ListDataKeys() ([]DataKey, error) {
+	keys := []DataKey{}
+	for _, ownerId := range store.keyDB.GetPKs() {
+		ownerKeys, _ := store.GetDataKeys(ownerId)
+		keys = append(keys, ownerKeys...)
+	}
+	return keys, nil
+}
+
+func nameEntryKey(ownerId string, parentId string, name string) string {
+	return ownerId + "/" + parentId + "/" + name
+}
+
+func (store *MemoryStore) CreateNameEntry(entry *NameEntry) error {
+	store.namesMu.Lock()
+	defer store.namesMu.Unlock()
+	if store.names == nil {
+		store.names = map[string]NameEntry{}
+	}
+	key := nameEntryKey(entry.OwnerId, entry.ParentId, entry.Name)
+	if _, ok := store.names[key]; ok {
+		return ErrNameTaken
+	}
+	store.names[key] = *entry
+	return nil
+}
+
+func (store *MemoryStore) GetNameEntry(ownerId string, parentId string, name string) (*NameEntry, error) {
+	store.namesMu.Lock()
+	defer store.namesMu.Unlock()
+	entry, ok := store.names[nameEntryKey(ownerId, parentId, name)]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (store *MemoryStore) ListNameEntries(ownerId string) ([]NameEntry, error) {
+	store.namesMu.Lock()
+	defer store.namesMu.Unlock()
+	entries := []NameEntry{}
+	for _, entry := range store.names {
+		if entry.OwnerId == ownerId {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (store *MemoryStore) DeleteNameEntry(ownerId string, parentId string, name string) error {
+	store.namesMu.Lock()
+	defer store.namesMu.Unlock()
+	delete(store.names, nameEntryKey(ownerId, parentId, name))
+	return nil
+}
+
+func (store *MemoryStore) WriteFragmentData(ownerId string, id string, data io.Reader) (int64, error) {
+	buffer, err := io.ReadAll(data)
+	if err != nil {
+		return 0, err
+	}
+	store.dataDB.Put(ownerId, id, memoryBlob{buffer, time.Now()})
+	return int64(len(buffer)), nil
+}
+
+// Blobs are replaced rather than modified, so readers can share the stored slice.
+func (store *MemoryStore) ReadFragmentData(ownerId string, id string) (io.ReadCloser, error) {
+	value, ok := store.dataDB.GetValue(ownerId, id)
+	if !ok {
+		return nil, ErrFragmentDataNotFound
+	}
+	blob, ok := value.(memoryBlob)
+	if !ok {
+		return nil, fmt.Errorf("unexpected fragment data type for userid: %s and fragment_id: %s", ownerId, id)
+	}
+	return io.NopCloser(bytes.NewReader(blob.data)), nil
+}
+
+func (store *MemoryStore) ReadFragmentDataRange(ownerId string, id string, offset int64, length int64) (io.ReadCloser, error) {
+	value, ok := store.dataDB.GetValue(ownerId, id)
+	if !ok {
+		return nil, ErrFragmentDataNotFound
+	}
+	blob, ok := value.(memoryBlob)
+	if !ok {
+		return nil, fmt.Errorf("unexpected fragment data type for userid: %s and fragment_id: %s", ownerId, id)
+	}
+	if offset < 0 || length < 0 || offset+length > int64(len(blob.data)) {
+		return nil, fmt.Errorf("range %d+%d is outside of the fragment data for userid: %s and fragment_id: %s", offset, length, ownerId, id)
+	}
+	return io.NopCloser(bytes.NewReader(blob.data[offset : offset+length])), nil
+}
+
+func (store *MemoryStore) DeleteFragmentData(ownerId string, id string) error {
+	store.dataDB.DeleteValue(ownerId, id)
+	return nil
+}
+
+func (store *MemoryStore) HasFragmentData(ownerId string, id string) (bool, error) {
+	_, ok := store.dataDB.GetValue(ownerId, id)
+	return ok, nil
+}
+
+func (store *MemoryStore) CopyFragmentData(srcOwnerId string, srcId string, dstOwnerId string, dstId string) error {
+	value, ok := store.dataDB.GetValue(srcOwnerId, srcId)
+	if !ok {
+		return ErrFragmentDataNotFound
+	}
+	blob, ok := value.(memoryBlob)
+	if !ok {
+		return fmt.Errorf("unexpected fragment data type for userid: %s and fragment_id: %s", srcOwnerId, srcId)
+	}
+	store.dataDB.Put(dstOwnerId, dstId, memoryBlob{blob.data, time.Now()})
+	return nil
+}
+
+func (store *MemoryStore) ListDataOwnerIds() ([]string, error) {
+	return store.dataDB.GetPKs(), nil
+}
+
+func (store *MemoryStore) ListFragmentData(ownerId string) ([]BlobInfo, error) {
+	blobs := []BlobInfo{}
+	for _, key := range store.dataDB.GetSKs(ownerId) {
+		value, ok := store.dataDB.GetValue(ownerId, key)
+		if !ok {
+			// Deleted since the keys were listed
+			continue
+		}
+		if blob, ok := value.(memoryBlob); ok {
+			blobs = append(blobs, BlobInfo{key, int64(len(blob.data)), blob.lastModified})
+		}
+	}
+	return blobs, nil
+}
+
+// Removes every fragment and its data from the store
+func (store *MemoryStore) Reset() {
+	store.fragmentDB.Reset()
+	store.dataDB.Reset()
+	store.versionDB.Reset()
+	store.keyDB.Reset()
+	store.refsMu.Lock()
+	store.blobRefs = nil
+	store.refsMu.Unlock()
+	store.namesMu.Lock()
+	store.names = nil
+	store.namesMu.Unlock()
+}
@@ -0,0 +1,188 @@
+package fragment
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidMetadata = errors.New("invalid fragment metadata")
+
+// Limits on the tags and custom metadata of a fragment. Metadata keys are
+// restricted to characters that can be sent in header names.
+const (
+	maxTags                = 32
+	maxTagLength           = 64
+	maxMetadataEntries     = 32
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 1024
+)
+
+// Changes to a fragment's tags, custom metadata, name and collection. Fields
+// that are nil are kept. Tags are replaced when set. Metadata entries are
+// merged into the existing ones, a nil value removes the entry. An empty name
+// takes the fragment out of its collection.
+type MetadataUpdate struct {
+	Tags         *[]string          `json:"tags"`
+	Metadata     map[string]*string `json:"metadata"`
+	Name         *string            `json:"name"`
+	CollectionId *string            `json:"collectionId"`
+}
+
+// Validates tags and returns them trimmed, lower cased and without
+// duplicates, in their original order. Never returns a nil slice, so the
+// result can be told apart from tags that weren't given.
+func NormalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxTagLength || strings.ContainsAny(tag, ",\r\n") {
+			return nil, fmt.Errorf("%w: tags must be 1 to %d characters long and can't contain commas", ErrInvalidMetadata, maxTagLength)
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("%w: a fragment can have at most %d tags", ErrInvalidMetadata, maxTags)
+	}
+	return normalized, nil
+}
+
+// Validates custom metadata and returns it with lower cased keys. Never
+// returns a nil map, like NormalizeTags.
+func NormalizeMetadata(metadata map[string]string) (map[string]string, error) {
+	normalized := map[string]string{}
+	for key, value := range metadata {
+		key = strings.ToLower(key)
+		if !validMetadataKey(key) {
+			return nil, fmt.Errorf("%w: metadata keys must be 1 to %d letters, digits, dashes or underscores", ErrInvalidMetadata, maxMetadataKeyLength)
+		}
+		if len(value) > maxMetadataValueLength {
+			return nil, fmt.Errorf("%w: metadata values can be at most %d bytes long", ErrInvalidMetadata, maxMetadataValueLength)
+		}
+		normalized[key] = value
+	}
+	if len(normalized) > maxMetadataEntries {
+		return nil, fmt.Errorf("%w: a fragment can have at most %d metadata entries", ErrInvalidMetadata, maxMetadataEntries)
+	}
+	return normalized, nil
+}
+
+func validMetadataKey(key string) bool {
+	if key == "" || len(key) > maxMetadataKeyLength {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether the fragment has every one of the tags.
+func (frag *Fragment) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(frag.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Applies the update to the current metadata of the fragment. Like moving a
+// fragment to the trash this doesn't create a new version, the versions keep
+// the tags and metadata they were written with. Updated and the
+// MetadataRevision change instead, which changes the InfoETag.
+//
+// Returns ErrNameTaken when the collection already holds something with the
+// name and ErrCollectionNotFound when the collection doesn't exist.
+//
+// Returns nil, nil when the fragment doesn't exist or is in the trash.
+func UpdateFragmentMetadata(username string, fragment_id string, update MetadataUpdate) (*Fragment, error) {
+	return UpdateFragmentMetadataIf(username, fragment_id, update, nil)
+}
+
+// Like UpdateFragmentMetadata but returns ErrPreconditionFailed unless the
+// precondition holds for the metadata the update is applied to.
+func UpdateFragmentMetadataIf(username string, fragment_id string, update MetadataUpdate, precondition Precondition) (*Fragment, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	frag, err := retryChanged(username, fragment_id, func() (*Fragment, error) {
+		current, err := GetFragment(username, fragment_id)
+		if err != nil {
+			return nil, err
+		}
+		if precondition != nil && !precondition(current) {
+			return nil, ErrPreconditionFailed
+		}
+		if current == nil {
+			return nil, nil
+		}
+		return applyMetadataUpdate(store, current, update)
+	})
+	if err != nil || frag == nil {
+		return nil, err
+	}
+	updateSearchMetadata(frag)
+	return frag, nil
+}
+
+// Writes the update applied to current unless the fragment changed since
+// current was read.
+func applyMetadataUpdate(store FragmentStore, current *Fragment, update MetadataUpdate) (*Fragment, error) {
+	frag := *current
+	var err error
+	if update.Tags != nil {
+		if frag.Tags, err = NormalizeTags(*update.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if update.Metadata != nil {
+		// Stored values may be shared with other copies of the fragment, so they are never modified in place
+		metadata := maps.Clone(frag.Metadata)
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		for key, value := range update.Metadata {
+			if value == nil {
+				delete(metadata, strings.ToLower(key))
+			} else {
+				metadata[strings.ToLower(key)] = *value
+			}
+		}
+		if frag.Metadata, err = NormalizeMetadata(metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	finish := func(bool) {}
+	if update.Name != nil || update.CollectionId != nil {
+		name, collectionId := frag.Name, frag.CollectionId
+		if update.Name != nil {
+			name = *update.Name
+		}
+		if update.CollectionId != nil {
+			collectionId = *update.CollectionId
+		} else if name == "" {
+			collectionId = ""
+		}
+		if finish, err = frag.relocate(store, collectionId, name); err != nil {
+			return nil, err
+		}
+	}
+	frag.Updated = time.Now()
+	frag.MetadataRevision++
+	err = store.ReplaceFragment(&frag, current)
+	finish(err == nil)
+	if err != nil {
+		return nil, err
+	}
+	return &frag, nil
+}
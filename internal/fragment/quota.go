@@ -0,0 +1,95 @@
+package fragment
+
+import (
+	"errors"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+)
+
+var ErrFragmentTooLarge = errors.New("fragment exceeds the maximum fragment size")
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Storage used by a user, computed from the Size of their fragments.
+// Fragments in the trash count towards the quota until they are purged.
+type Usage struct {
+	Fragments        int   `json:"fragments"`
+	Bytes            int64 `json:"bytes"`
+	TrashedFragments int   `json:"trashedFragments"`
+	TrashedBytes     int64 `json:"trashedBytes"`
+}
+
+// Configured limits, 0 means unlimited.
+type Limits struct {
+	MaxFragmentSize int64 `json:"maxFragmentSize"`
+	MaxBytes        int64 `json:"maxBytes"`
+	MaxFragments    int64 `json:"maxFragments"`
+}
+
+func GetLimits() Limits {
+	return Limits{config.MaxFragmentSize, config.QuotaBytes, config.QuotaFragments}
+}
+
+func GetUsage(username string) (*Usage, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	// A listing without a limit holds the size of every fragment
+	listing, err := store.ListFragments(username, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	usage := &Usage{}
+	for _, frag := range listing.Fragments {
+		usage.Fragments++
+		usage.Bytes += int64(frag.Size)
+	}
+	trashed, err := store.GetTrashedFragments(username)
+	if err != nil {
+		return nil, err
+	}
+	for _, frag := range trashed {
+		usage.TrashedFragments++
+		usage.TrashedBytes += int64(frag.Size)
+	}
+	return usage, nil
+}
+
+// Checks that writing size bytes to the fragment stays within the configured
+// limits. The current size of the fragment is discounted when it is updated.
+//
+// Concurrent writes are checked independently, so they can overshoot the quota
+// by at most one fragment each.
+func CheckQuota(username string, fragment_id string, size int64) error {
+	limits := GetLimits()
+	if limits.MaxFragmentSize > 0 && size > limits.MaxFragmentSize {
+		return ErrFragmentTooLarge
+	}
+	if limits.MaxBytes <= 0 && limits.MaxFragments <= 0 {
+		return nil
+	}
+
+	usage, err := GetUsage(username)
+	if err != nil {
+		return err
+	}
+	fragments := int64(usage.Fragments + usage.TrashedFragments)
+	bytes := usage.Bytes + usage.TrashedBytes + size
+	current, err := ReadFragment(username, fragment_id)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		bytes -= int64(current.Size)
+	} else {
+		fragments++
+	}
+
+	if limits.MaxFragments > 0 && fragments > limits.MaxFragments {
+		return ErrQuotaExceeded
+	}
+	if limits.MaxBytes > 0 && bytes > limits.MaxBytes {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
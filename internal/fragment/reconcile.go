@@ -0,0 +1,309 @@
+package fragment
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+type DiscrepancyKind string
+
+const (
+	// A blob that no fragment references
+	OrphanData DiscrepancyKind = "orphan_data"
+	// A fragment whose blob doesn't exist
+	MissingData DiscrepancyKind = "missing_data"
+	// A fragment whose size doesn't match the size of its blob
+	SizeMismatch DiscrepancyKind = "size_mismatch"
+)
+
+type Discrepancy struct {
+	Kind       DiscrepancyKind `json:"kind"`
+	OwnerId    string          `json:"ownerId"`
+	FragmentId string          `json:"fragmentId,omitempty"`
+	DataKey    string          `json:"dataKey"`
+	// Size of the stored data recorded in the fragment's metadata
+	MetadataSize int `json:"metadataSize,omitempty"`
+	// Size of the stored blob
+	DataSize int64  `json:"dataSize,omitempty"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ReconcileOptions struct {
+	// Only report discrepancies, don't repair them
+	DryRun bool
+	// Blobs younger than this are skipped when looking for orphans since they
+	// may belong to a write that hasn't committed its metadata yet
+	MinOrphanAge time.Duration
+	// Limits the run to these owners. Every owner is checked when empty
+	OwnerIds []string
+}
+
+type ReconcileReport struct {
+	DryRun           bool          `json:"dryRun"`
+	OwnersChecked    int           `json:"ownersChecked"`
+	FragmentsChecked int           `json:"fragmentsChecked"`
+	BlobsChecked     int           `json:"blobsChecked"`
+	Discrepancies    []Discrepancy `json:"discrepancies"`
+}
+
+// Compares the metadata store with the blob store owner by owner, reports
+// every discrepancy and, unless it is a dry run, repairs them:
+//   - orphan blobs are deleted
+//   - fragments with missing data are marked as broken
+//   - fragment sizes are corrected to the size of their blob
+func Reconcile(options ReconcileOptions) (*ReconcileReport, error) {
+	metadataStore, dataStore, err := getStores()
+	if err != nil {
+		return nil, err
+	}
+
+	ownerIds := options.OwnerIds
+	if len(ownerIds) == 0 {
+		if ownerIds, err = reconcileOwnerIds(metadataStore, dataStore); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ReconcileReport{DryRun: options.DryRun, Discrepancies: []Discrepancy{}}
+	shared := &sharedBlobs{dataStore: dataStore, listings: map[string]map[string]BlobInfo{}}
+	for _, ownerId := range ownerIds {
+		if err := reconcileOwner(metadataStore, dataStore, ownerId, options, report, shared); err != nil {
+			return report, err
+		}
+		report.OwnersChecked++
+	}
+	return report, nil
+}
+
+func reconcileOwnerIds(metadataStore FragmentStore, dataStore BlobStore) ([]string, error) {
+	ownerIds, err := metadataStore.GetOwnerIds()
+	if err != nil {
+		return nil, err
+	}
+	dataOwnerIds, err := dataStore.ListDataOwnerIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, ownerId := range dataOwnerIds {
+		if !slices.Contains(ownerIds, ownerId) {
+			ownerIds = append(ownerIds, ownerId)
+		}
+	}
+	return ownerIds, nil
+}
+
+// Lists the blobs of other owner namespaces that fragments point at, each at most once per run
+type sharedBlobs struct {
+	dataStore BlobStore
+	listings  map[string]map[string]BlobInfo
+}
+
+func (shared *sharedBlobs) get(dataOwnerId string, key string) (BlobInfo, bool, error) {
+	listing, ok := shared.listings[dataOwnerId]
+	if !ok {
+		blobs, err := shared.dataStore.ListFragmentData(dataOwnerId)
+		if err != nil {
+			return BlobInfo{}, false, err
+		}
+		listing = map[string]BlobInfo{}
+		for _, blob := range blobs {
+			listing[blob.Key] = blob
+		}
+		shared.listings[dataOwnerId] = listing
+	}
+	blob, ok := listing[key]
+	return blob, ok, nil
+}
+
+func reconcileOwner(metadataStore FragmentStore, dataStore BlobStore, ownerId string, options ReconcileOptions, report *ReconcileReport, shared *sharedBlobs) error {
+	// Blobs are listed before the metadata is read. A write committing in
+	// between then shows up as a young orphan, which is skipped, or as missing
+	// data, which is checked again before anything is changed.
+	blobs, err := dataStore.ListFragmentData(ownerId)
+	if err != nil {
+		return err
+	}
+	report.BlobsChecked += len(blobs)
+	blobsByKey := map[string]BlobInfo{}
+	for _, blob := range blobs {
+		blobsByKey[blob.Key] = blob
+	}
+
+	ids, err := metadataStore.GetFragmentIds(ownerId)
+	if err != nil {
+		return err
+	}
+	// Fragments in the trash still own their data
+	trashed, err := metadataStore.GetTrashedFragments(ownerId)
+	if err != nil {
+		return err
+	}
+	for _, frag := range trashed {
+		ids = append(ids, frag.Id)
+	}
+	referenced := map[string]bool{}
+	for _, id := range ids {
+		frag, err := metadataStore.GetFragment(ownerId, id)
+		if err != nil {
+			return err
+		}
+		if frag == nil {
+			continue
+		}
+		report.FragmentsChecked++
+		// Blobs of previous versions are referenced as well
+		dataKeys, err := fragmentDataKeys(metadataStore, frag)
+		if err != nil {
+			return err
+		}
+		for _, dataKey := range dataKeys {
+			referenced[dataKey] = true
+		}
+
+		blob, ok := blobsByKey[frag.dataKey()]
+		if frag.dataOwnerId() != ownerId {
+			if blob, ok, err = shared.get(frag.dataOwnerId(), frag.dataKey()); err != nil {
+				return err
+			}
+		}
+		if !ok {
+			if frag.Broken {
+				continue
+			}
+			discrepancy := Discrepancy{Kind: MissingData, OwnerId: ownerId, FragmentId: id, DataKey: frag.dataKey(), MetadataSize: frag.storedSize()}
+			if !options.DryRun {
+				discrepancy.Repaired, err = markBroken(metadataStore, dataStore, frag)
+				discrepancy.Error = errorString(err)
+			}
+			report.Discrepancies = append(report.Discrepancies, discrepancy)
+			continue
+		}
+		if int64(frag.storedSize()) != blob.Size {
+			discrepancy := Discrepancy{Kind: SizeMismatch, OwnerId: ownerId, FragmentId: id, DataKey: frag.dataKey(), MetadataSize: frag.storedSize(), DataSize: blob.Size}
+			if !options.DryRun {
+				discrepancy.Repaired, err = correctSize(metadataStore, frag, blob)
+				discrepancy.Error = errorString(err)
+			}
+			report.Discrepancies = append(report.Discrepancies, discrepancy)
+		}
+	}
+
+	for _, blob := range blobs {
+		if referenced[blob.Key] || time.Since(blob.LastModified) < options.MinOrphanAge {
+			continue
+		}
+		// Deduplicated blobs may be referenced by other owners, their reference count decides
+		if isContentKey(blob.Key) {
+			refs, err := metadataStore.GetBlobRefs(ownerId, blob.Key)
+			if err != nil {
+				return err
+			}
+			if refs > 0 {
+				continue
+			}
+		}
+		discrepancy := Discrepancy{Kind: OrphanData, OwnerId: ownerId, DataKey: blob.Key, DataSize: blob.Size}
+		if !options.DryRun && isContentKey(blob.Key) {
+			deleted, err := deleteContentBlob(metadataStore, dataStore, ownerId, blob.Key)
+			if !deleted && err == nil {
+				// Referenced again since the count was read
+				continue
+			}
+			discrepancy.Repaired, discrepancy.Error = deleted, errorString(err)
+		} else if !options.DryRun {
+			err = dataStore.DeleteFragmentData(ownerId, blob.Key)
+			discrepancy.Repaired, discrepancy.Error = err == nil, errorString(err)
+		}
+		report.Discrepancies = append(report.Discrepancies, discrepancy)
+	}
+	return nil
+}
+
+// Marks the fragment as broken after checking that its data is still missing
+// and that it wasn't updated since it was read.
+func markBroken(metadataStore FragmentStore, dataStore BlobStore, frag *Fragment) (bool, error) {
+	current, err := metadataStore.GetFragment(frag.OwnerId, frag.Id)
+	if err != nil || current == nil || current.dataKey() != frag.dataKey() {
+		return false, err
+	}
+	reader, err := dataStore.ReadFragmentData(frag.dataOwnerId(), frag.dataKey())
+	if err == nil {
+		reader.Close()
+		return false, nil
+	}
+	if !errors.Is(err, ErrFragmentDataNotFound) {
+		return false, err
+	}
+	marked := *current
+	marked.Broken = true
+	logger.Sugar.Warnf("Marking fragment as broken. User ID: %s. Fragment ID: %s", frag.OwnerId, frag.Id)
+	if err := metadataStore.ReplaceFragment(&marked, current); errors.Is(err, ErrFragmentChanged) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Sets the fragment's stored size to the size of its blob unless it was
+// updated since it was read. The size of fragments stored as is is corrected
+// along with it.
+func correctSize(metadataStore FragmentStore, frag *Fragment, blob BlobInfo) (bool, error) {
+	current, err := metadataStore.GetFragment(frag.OwnerId, frag.Id)
+	if err != nil || current == nil || current.dataKey() != blob.Key {
+		return false, err
+	}
+	corrected := *current
+	if current.storedAsIs() {
+		corrected.Size = int(blob.Size)
+	}
+	corrected.StoredSize = int(blob.Size)
+	if err := metadataStore.ReplaceFragment(&corrected, current); errors.Is(err, ErrFragmentChanged) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Runs Reconcile every interval until the returned function is called.
+func StartReconciler(interval time.Duration, options ReconcileOptions) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report, err := Reconcile(options)
+				if err != nil {
+					logger.Sugar.Error("Reconciliation failed: ", err)
+				}
+				if report != nil {
+					logger.Sugar.Infow("Reconciliation finished", "dryRun", report.DryRun, "owners", report.OwnersChecked,
+						"fragments", report.FragmentsChecked, "blobs", report.BlobsChecked, "discrepancies", len(report.Discrepancies))
+					for _, discrepancy := range report.Discrepancies {
+						logger.Sugar.Warnw("Storage discrepancy", "kind", discrepancy.Kind, "ownerId", discrepancy.OwnerId,
+							"fragmentId", discrepancy.FragmentId, "dataKey", discrepancy.DataKey, "repaired", discrepancy.Repaired)
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
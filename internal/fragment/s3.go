@@ -1,21 +1,26 @@
 package fragment
 
 import (
-	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
-	"os"
+	"net/url"
+	"strings"
 
 	"github.com/Jashanpreet2/fragments/internal/config"
 	"github.com/Jashanpreet2/fragments/internal/logger"
 	"github.com/aws/aws-sdk-go-v2/aws"
-	s3Config "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type S3Client struct {
 	*s3.Client
+	Bucket string
+	// Streams uploads, switching to multipart uploads for large fragments
+	uploader *manager.Uploader
 }
 
 var s3Client *S3Client
@@ -26,53 +31,115 @@ func GetS3Client() (*S3Client, error) {
 	}
 
 	config.Config()
-	cfg, err := s3Config.LoadDefaultConfig(context.TODO())
+	client, err := NewS3Client(config.AWS)
 	if err != nil {
 		logger.Sugar.Infow("Error loading default config", "Error", err.Error())
 		return nil, err
 	}
-
-	s3Client = &S3Client{s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.Region = "us-east-1"
-	})}
+	s3Client = client
 
 	return s3Client, nil
 }
 
-func (s3Client *S3Client) GetFragmentDataFromS3(username string, key string) ([]byte, error) {
-	logger.Sugar.Infof("Bucket name: " + os.Getenv("S3_BUCKET"))
+func NewS3Client(awsCfg config.AWSConfig) (*S3Client, error) {
+	cfg, err := loadAWSConfig(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if awsCfg.S3EndpointURL != "" {
+			o.BaseEndpoint = aws.String(awsCfg.S3EndpointURL)
+		}
+		o.UsePathStyle = awsCfg.S3UsePathStyle
+	})
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		// The uploader only buffers one part per concurrent upload and sends
+		// bodies that fit in a single part with a plain PutObject
+		u.PartSize = max(awsCfg.S3MultipartThreshold, manager.MinUploadPartSize)
+	})
+	return &S3Client{client, awsCfg.S3BucketName, uploader}, nil
+}
+
+// Returns the object's body, which the caller must close.
+func (s3Client *S3Client) GetFragmentDataFromS3(username string, key string) (io.ReadCloser, error) {
+	logger.Sugar.Infof("Bucket name: %s", s3Client.Bucket)
 	object, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket:       aws.String(os.Getenv("S3_BUCKET")),
+		Bucket:       aws.String(s3Client.Bucket),
 		Key:          aws.String(username + "/" + key),
 		ChecksumMode: types.ChecksumModeEnabled,
 	})
 
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrFragmentDataNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+	return object.Body, nil
+}
 
-	return io.ReadAll(object.Body)
+func (s3Client *S3Client) ReadFragmentData(ownerId string, fragmentId string) (io.ReadCloser, error) {
+	return s3Client.GetFragmentDataFromS3(ownerId, fragmentId)
 }
 
-func (s3Client *S3Client) UploadFragmentDataToS3(username string, key string, data []byte) error {
+// Fetches only the requested bytes from S3.
+func (s3Client *S3Client) ReadFragmentDataRange(ownerId string, fragmentId string, offset int64, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	object, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s3Client.Bucket),
+		Key:    aws.String(ownerId + "/" + fragmentId),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrFragmentDataNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return object.Body, nil
+}
+
+func (s3Client *S3Client) WriteFragmentData(ownerId string, fragmentId string, data io.Reader) (int64, error) {
+	return s3Client.UploadFragmentDataToS3(ownerId, fragmentId, data)
+}
+
+// Streams the data to S3 and returns the number of bytes uploaded.
+func (s3Client *S3Client) UploadFragmentDataToS3(username string, key string, data io.Reader) (int64, error) {
 	logger.Sugar.Info("Uploading fragment to s3. Username: " + username + " | Key: " + key)
-	_, err := s3Client.PutObject(context.Background(), &s3.PutObjectInput{
-		Bucket:            aws.String(os.Getenv("S3_BUCKET")),
+	counter := &countingReader{reader: data}
+	_, err := s3Client.uploader.Upload(context.Background(), &s3.PutObjectInput{
+		Bucket:            aws.String(s3Client.Bucket),
 		Key:               aws.String(username + "/" + key),
 		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
-		Body:              bytes.NewReader(data),
+		Body:              counter,
 	})
 	if err != nil {
 		logger.Sugar.Info("Failed to upload fragment to s3", err)
-	} else {
-		logger.Sugar.Info("Successfully uploaded fragment to s3")
+		return 0, err
 	}
-	return err
+	logger.Sugar.Info("Successfully uploaded fragment to s3")
+	return counter.count, nil
 }
 
-func (s3Client *S3Client) deleteFragment(ownerId string, fragmentId string) error {
+// Counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (counter *countingReader) Read(p []byte) (int, error) {
+	n, err := counter.reader.Read(p)
+	counter.count += int64(n)
+	return n, err
+}
+
+func (s3Client *S3Client) DeleteFragmentData(ownerId string, fragmentId string) error {
 	_, err := s3Client.Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(os.Getenv("S3_BUCKET")),
+		Bucket: aws.String(s3Client.Bucket),
 		Key:    aws.String(ownerId + "/" + fragmentId),
 	})
 	if err != nil {
@@ -80,3 +147,71 @@ func (s3Client *S3Client) deleteFragment(ownerId string, fragmentId string) erro
 	}
 	return nil
 }
+
+func (s3Client *S3Client) HasFragmentData(ownerId string, fragmentId string) (bool, error) {
+	_, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(s3Client.Bucket),
+		Key:    aws.String(ownerId + "/" + fragmentId),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Copies the object within the bucket without downloading it. S3 limits single
+// copies to objects of up to 5 GB.
+func (s3Client *S3Client) CopyFragmentData(srcOwnerId string, srcId string, dstOwnerId string, dstId string) error {
+	_, err := s3Client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+		Bucket:     aws.String(s3Client.Bucket),
+		CopySource: aws.String(s3Client.Bucket + "/" + url.PathEscape(srcOwnerId) + "/" + url.PathEscape(srcId)),
+		Key:        aws.String(dstOwnerId + "/" + dstId),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return ErrFragmentDataNotFound
+	}
+	return err
+}
+
+func (s3Client *S3Client) ListDataOwnerIds() ([]string, error) {
+	ownerIds := []string{}
+	paginator := s3.NewListObjectsV2Paginator(s3Client.Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s3Client.Bucket),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, prefix := range page.CommonPrefixes {
+			ownerIds = append(ownerIds, strings.TrimSuffix(aws.ToString(prefix.Prefix), "/"))
+		}
+	}
+	return ownerIds, nil
+}
+
+func (s3Client *S3Client) ListFragmentData(ownerId string) ([]BlobInfo, error) {
+	blobs := []BlobInfo{}
+	prefix := ownerId + "/"
+	paginator := s3.NewListObjectsV2Paginator(s3Client.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s3Client.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			blobs = append(blobs, BlobInfo{
+				Key:          strings.TrimPrefix(aws.ToString(object.Key), prefix),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+	return blobs, nil
+}
@@ -0,0 +1,364 @@
+package fragment
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/logger"
+	_ "modernc.org/sqlite"
+)
+
+// SearchIndex is a full-text index over the contents, types, tags and custom
+// metadata of fragments, kept in a local SQLite database with the FTS5
+// extension. Every server instance keeps its own index, which can be rebuilt
+// from the stores at any time with RebuildSearchIndex.
+//
+// The indexed text is stored in the clear, so the contents of encrypted
+// fragments are only indexed when config.SearchEncrypted is set.
+type SearchIndex struct {
+	db *sql.DB
+}
+
+// A fragment matching a search, with an excerpt of its matching content.
+type SearchResult struct {
+	Id      string  `json:"id"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+func NewSearchIndex(path string) (*SearchIndex, error) {
+	if path == "" {
+		return nil, errors.New("a path is required for the search index")
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes the writes and keeps in-memory indexes alive
+	db.SetMaxOpenConns(1)
+	// The index can always be rebuilt, so the schema is created instead of
+	// migrated. An index from before tags were indexed is dropped and has to be
+	// rebuilt.
+	var current int
+	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('search_index') WHERE name = 'tags'`).Scan(&current)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	statements := []string{}
+	if current == 0 {
+		statements = append(statements, `DROP TABLE IF EXISTS search_index`, `DROP TABLE IF EXISTS search_documents`)
+	}
+	for _, statement := range append(statements,
+		`CREATE TABLE IF NOT EXISTS search_documents (
+			rowid    INTEGER PRIMARY KEY,
+			owner_id TEXT NOT NULL,
+			id       TEXT NOT NULL,
+			UNIQUE (owner_id, id)
+		)`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(fragment_type, content, tags, metadata)`,
+	) {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &SearchIndex{db}, nil
+}
+
+func (index *SearchIndex) Close() error {
+	return index.db.Close()
+}
+
+// Replaces the indexed text of the fragment with its type, tags, metadata and the content.
+func (index *SearchIndex) IndexFragment(frag *Fragment, content string) error {
+	tx, err := index.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var rowid int64
+	err = tx.QueryRow(`INSERT INTO search_documents (owner_id, id) VALUES (?, ?)
+		ON CONFLICT (owner_id, id) DO UPDATE SET id = excluded.id
+		RETURNING rowid`, frag.OwnerId, frag.Id).Scan(&rowid)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM search_index WHERE rowid = ?`, rowid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO search_index (rowid, fragment_type, content, tags, metadata) VALUES (?, ?, ?, ?, ?)`,
+		rowid, frag.FragmentType, strings.ToValidUTF8(content, ""), strings.Join(frag.Tags, " "), metadataText(frag)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Replaces the indexed tags and metadata of a fragment that is already indexed.
+func (index *SearchIndex) IndexMetadata(frag *Fragment) error {
+	_, err := index.db.Exec(`UPDATE search_index SET tags = ?, metadata = ?
+		WHERE rowid = (SELECT rowid FROM search_documents WHERE owner_id = ? AND id = ?)`,
+		strings.Join(frag.Tags, " "), metadataText(frag), frag.OwnerId, frag.Id)
+	return err
+}
+
+// Returns the custom metadata as text to index, ordered by key.
+func metadataText(frag *Fragment) string {
+	entries := []string{}
+	for _, key := range slices.Sorted(maps.Keys(frag.Metadata)) {
+		entries = append(entries, key+" "+strings.ToValidUTF8(frag.Metadata[key], ""))
+	}
+	return strings.Join(entries, "\n")
+}
+
+func (index *SearchIndex) RemoveFragment(ownerId string, id string) error {
+	tx, err := index.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var rowid int64
+	err = tx.QueryRow(`DELETE FROM search_documents WHERE owner_id = ? AND id = ? RETURNING rowid`, ownerId, id).Scan(&rowid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM search_index WHERE rowid = ?`, rowid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Removes every fragment from the index.
+func (index *SearchIndex) Clear() error {
+	if _, err := index.db.Exec(`DELETE FROM search_index`); err != nil {
+		return err
+	}
+	_, err := index.db.Exec(`DELETE FROM search_documents`)
+	return err
+}
+
+// Returns up to limit of the owner's fragments containing every word of the
+// query, best matches first. Words match regardless of case and punctuation
+// in the query is ignored.
+func (index *SearchIndex) Search(ownerId string, query string, limit int) ([]SearchResult, error) {
+	results := []SearchResult{}
+	match := matchExpression(query)
+	if match == "" {
+		return results, nil
+	}
+	// bm25 ranks better matches lower
+	rows, err := index.db.Query(`SELECT search_documents.id, -bm25(search_index), snippet(search_index, 1, '', '', '…', 16)
+		FROM search_index JOIN search_documents ON search_documents.rowid = search_index.rowid
+		WHERE search_index MATCH ? AND search_documents.owner_id = ?
+		ORDER BY bm25(search_index) LIMIT ?`, match, ownerId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(&result.Id, &result.Score, &result.Snippet); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// Turns the words of a user's query into an FTS5 expression matching all of
+// them, quoting each word so the query syntax can't be used.
+func matchExpression(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, word := range words {
+		words[i] = `"` + word + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+var searchIndexMu sync.Mutex
+var searchIndex *SearchIndex
+
+// Replaces the index used by the package level functions, closing the previous
+// one. When it is nil the index is opened from the configuration on next use.
+func SetSearchIndex(index *SearchIndex) {
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+	if searchIndex != nil && searchIndex != index {
+		searchIndex.Close()
+	}
+	searchIndex = index
+}
+
+func getSearchIndex() (*SearchIndex, error) {
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+	if searchIndex == nil {
+		index, err := NewSearchIndex(config.SearchIndexPath)
+		if err != nil {
+			return nil, err
+		}
+		searchIndex = index
+	}
+	return searchIndex, nil
+}
+
+// Searches the contents, types, tags and metadata of the user's fragments.
+func SearchFragments(username string, query string, limit int) ([]SearchResult, error) {
+	index, err := getSearchIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index.Search(username, query, limit)
+}
+
+// Reports whether the contents of fragments of the type are indexed
+func isSearchable(fragmentType string) bool {
+	mimeType := strings.TrimSpace(strings.Split(fragmentType, ";")[0])
+	return strings.HasPrefix(mimeType, "text/") || mimeType == "application/json"
+}
+
+// Collects the first bytes written to it, up to the limit, and drops the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+func (buffer *cappedBuffer) Write(p []byte) (int, error) {
+	if remaining := buffer.limit - int64(buffer.Len()); remaining > 0 {
+		buffer.Buffer.Write(p[:min(int64(len(p)), remaining)])
+	}
+	return len(p), nil
+}
+
+// Returns a buffer collecting the text to index while the data is written, or
+// nil when the contents of the fragment aren't indexed. The contents of
+// encrypted fragments are only indexed when config.SearchEncrypted is set.
+func newSearchText(fragmentType string, encrypted bool) *cappedBuffer {
+	if !isSearchable(fragmentType) || encrypted && !config.SearchEncrypted {
+		return nil
+	}
+	return &cappedBuffer{limit: config.SearchMaxBytes}
+}
+
+// Updates the index after a write. The index catching up is best effort: a
+// failure is logged and the index can be repaired with RebuildSearchIndex.
+func updateSearchIndex(frag *Fragment, text *cappedBuffer) {
+	index, err := getSearchIndex()
+	if err == nil {
+		content := ""
+		if text != nil {
+			content = text.String()
+		}
+		err = index.IndexFragment(frag, content)
+	}
+	if err != nil {
+		logger.Sugar.Warnf("Failed to index fragment for userid: %s and fragment_id: %s", frag.OwnerId, frag.Id)
+		logger.Sugar.Warn(err)
+	}
+}
+
+// Indexes the fragment's current data, reading it back from the blob store.
+func reindexFragment(frag *Fragment) error {
+	text := newSearchText(frag.FragmentType, frag.KeyId != "")
+	if text != nil {
+		reader, err := frag.OpenData()
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(text, reader, config.SearchMaxBytes)
+		reader.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	index, err := getSearchIndex()
+	if err != nil {
+		return err
+	}
+	content := ""
+	if text != nil {
+		content = text.String()
+	}
+	return index.IndexFragment(frag, content)
+}
+
+// Updates the indexed tags and metadata after they changed, on a best effort
+// basis like updateSearchIndex.
+func updateSearchMetadata(frag *Fragment) {
+	index, err := getSearchIndex()
+	if err == nil {
+		err = index.IndexMetadata(frag)
+	}
+	if err != nil {
+		logger.Sugar.Warnf("Failed to index the metadata of fragment for userid: %s and fragment_id: %s", frag.OwnerId, frag.Id)
+		logger.Sugar.Warn(err)
+	}
+}
+
+// Removes a fragment from the index on a best effort basis, like updateSearchIndex.
+func removeFromSearchIndex(ownerId string, id string) {
+	index, err := getSearchIndex()
+	if err == nil {
+		err = index.RemoveFragment(ownerId, id)
+	}
+	if err != nil {
+		logger.Sugar.Warnf("Failed to remove fragment from the search index for userid: %s and fragment_id: %s", ownerId, id)
+		logger.Sugar.Warn(err)
+	}
+}
+
+// Recreates the search index from every fragment that isn't in the trash and
+// returns how many were indexed. Fragments whose data can't be read are
+// logged and skipped.
+func RebuildSearchIndex() (int, error) {
+	index, err := getSearchIndex()
+	if err != nil {
+		return 0, err
+	}
+	store, err := getFragmentStore()
+	if err != nil {
+		return 0, err
+	}
+	ownerIds, err := store.GetOwnerIds()
+	if err != nil {
+		return 0, err
+	}
+	if err := index.Clear(); err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+	for _, ownerId := range ownerIds {
+		ids, err := store.GetFragmentIds(ownerId)
+		if err != nil {
+			return indexed, err
+		}
+		for i, result := range GetFragments(ownerId, ids) {
+			if result.Err == nil && result.Fragment != nil {
+				result.Err = reindexFragment(result.Fragment)
+			}
+			if result.Err != nil {
+				logger.Sugar.Warnf("Failed to index fragment for userid: %s and fragment_id: %s", ownerId, ids[i])
+				logger.Sugar.Warn(result.Err)
+				continue
+			}
+			if result.Fragment != nil {
+				indexed++
+			}
+		}
+	}
+	return indexed, nil
+}
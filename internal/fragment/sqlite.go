@@ -0,0 +1,604 @@
+package fragment
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/logger"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps fragment metadata in an embedded SQLite database so the
+// service can run as a single binary without DynamoDB.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// Schema migrations, applied in order. The number of applied migrations is
+// tracked with SQLite's user_version pragma, so existing entries must never be
+// edited; add a new entry instead.
+var sqliteMigrations = [][]string{
+	{
+		`CREATE TABLE fragments (
+			owner_id      TEXT    NOT NULL,
+			id            TEXT    NOT NULL,
+			created       INTEGER NOT NULL,
+			updated       INTEGER NOT NULL,
+			fragment_type TEXT    NOT NULL,
+			size          INTEGER NOT NULL,
+			PRIMARY KEY (owner_id, id)
+		)`,
+		`CREATE INDEX fragments_owner_type ON fragments (owner_id, fragment_type)`,
+		`CREATE INDEX fragments_owner_updated ON fragments (owner_id, updated)`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN data_key TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN broken INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN version INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE fragment_versions (
+			owner_id      TEXT    NOT NULL,
+			id            TEXT    NOT NULL,
+			created       INTEGER NOT NULL,
+			updated       INTEGER NOT NULL,
+			fragment_type TEXT    NOT NULL,
+			size          INTEGER NOT NULL,
+			data_key      TEXT    NOT NULL DEFAULT '',
+			broken        INTEGER NOT NULL DEFAULT 0,
+			version       INTEGER NOT NULL,
+			PRIMARY KEY (owner_id, id, version)
+		)`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN deleted INTEGER`,
+		`ALTER TABLE fragment_versions ADD COLUMN deleted INTEGER`,
+		`CREATE INDEX fragments_owner_deleted ON fragments (owner_id, deleted)`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragment_versions ADD COLUMN hash TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN data_owner_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragment_versions ADD COLUMN data_owner_id TEXT NOT NULL DEFAULT ''`,
+		`CREATE TABLE blob_refs (
+			owner_id TEXT    NOT NULL,
+			data_key TEXT    NOT NULL,
+			refs     INTEGER NOT NULL,
+			PRIMARY KEY (owner_id, data_key)
+		)`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN encoding TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragments ADD COLUMN stored_size INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE fragment_versions ADD COLUMN encoding TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragment_versions ADD COLUMN stored_size INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN key_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragment_versions ADD COLUMN key_id TEXT NOT NULL DEFAULT ''`,
+		`CREATE TABLE data_keys (
+			owner_id      TEXT    NOT NULL,
+			id            TEXT    NOT NULL,
+			wrapped_key   BLOB    NOT NULL,
+			master_key_id TEXT    NOT NULL,
+			created       INTEGER NOT NULL,
+			PRIMARY KEY (owner_id, id)
+		)`,
+	},
+	{
+		// JSON encoded, empty when the fragment has none
+		`ALTER TABLE fragments ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragments ADD COLUMN metadata TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragment_versions ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragment_versions ADD COLUMN metadata TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN name TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragments ADD COLUMN collection_id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragment_versions ADD COLUMN name TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE fragment_versions ADD COLUMN collection_id TEXT NOT NULL DEFAULT ''`,
+		`CREATE TABLE name_entries (
+			owner_id  TEXT    NOT NULL,
+			parent_id TEXT    NOT NULL,
+			name      TEXT    NOT NULL,
+			kind      TEXT    NOT NULL,
+			id        TEXT    NOT NULL,
+			created   INTEGER NOT NULL,
+			PRIMARY KEY (owner_id, parent_id, name)
+		)`,
+	},
+	{
+		`ALTER TABLE fragments ADD COLUMN metadata_revision INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE fragment_versions ADD COLUMN metadata_revision INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		// Listings are sorted by one of these columns and then by id
+		`CREATE INDEX fragments_owner_created ON fragments (owner_id, created, id)`,
+		`DROP INDEX fragments_owner_updated`,
+		`CREATE INDEX fragments_owner_updated ON fragments (owner_id, updated, id)`,
+		`CREATE INDEX fragments_owner_size ON fragments (owner_id, size, id)`,
+		`DROP INDEX fragments_owner_type`,
+		`CREATE INDEX fragments_owner_type ON fragments (owner_id, ` + sqliteBaseType + `)`,
+	},
+}
+
+// The fragment type without parameters and lower cased, which is what type
+// filters are compared with. Indexed, so it must not be changed.
+const sqliteBaseType = `lower(trim(substr(fragment_type, 1, instr(fragment_type || ';', ';') - 1)))`
+
+// Columns that listings can be sorted by, keyed by sort field
+var sqliteSortColumns = map[string]string{SortCreated: "created", SortUpdated: "updated", SortSize: "size"}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if path == "" {
+		return nil, errors.New("a database path is required for the sqlite metadata backend")
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	store := &SQLiteStore{db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *SQLiteStore) migrate() error {
+	var version int
+	if err := store.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := store.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, statement := range sqliteMigrations[version] {
+			if _, err := tx.Exec(statement); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("sqlite migration %d failed: %w", version+1, err)
+			}
+		}
+		// Pragmas can't take bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		logger.Sugar.Infof("Applied sqlite migration %d", version+1)
+	}
+	return nil
+}
+
+func (store *SQLiteStore) Close() error {
+	return store.db.Close()
+}
+
+// Columns shared by the fragments and fragment_versions tables, in the order
+// used by fragmentValues and scanFragment
+const fragmentColumns = `owner_id, id, created, updated, fragment_type, size, data_key, broken, version, deleted, hash, data_owner_id, encoding, stored_size, key_id, tags, metadata, name, collection_id, metadata_revision`
+
+func fragmentValues(frag *Fragment) []any {
+	var deleted sql.NullInt64
+	if frag.Deleted != nil {
+		deleted = sql.NullInt64{Int64: frag.Deleted.UnixNano(), Valid: true}
+	}
+	return []any{frag.OwnerId, frag.Id, frag.Created.UnixNano(), frag.Updated.UnixNano(), frag.FragmentType,
+		frag.Size, frag.DataKey, frag.Broken, frag.Version, deleted, frag.Hash, frag.DataOwnerId, frag.Encoding, frag.StoredSize, frag.KeyId,
+		jsonColumn(len(frag.Tags), frag.Tags), jsonColumn(len(frag.Metadata), frag.Metadata), frag.Name, frag.CollectionId, frag.MetadataRevision}
+}
+
+// Encodes a slice or map with length n, or returns an empty string when it is empty
+func jsonColumn(n int, value any) string {
+	if n == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFragment(row rowScanner) (*Fragment, error) {
+	var frag Fragment
+	var created, updated int64
+	var deleted sql.NullInt64
+	var tags, metadata string
+	err := row.Scan(&frag.OwnerId, &frag.Id, &created, &updated, &frag.FragmentType, &frag.Size,
+		&frag.DataKey, &frag.Broken, &frag.Version, &deleted, &frag.Hash, &frag.DataOwnerId, &frag.Encoding, &frag.StoredSize, &frag.KeyId,
+		&tags, &metadata, &frag.Name, &frag.CollectionId, &frag.MetadataRevision)
+	if err != nil {
+		return nil, err
+	}
+	if tags != "" {
+		if err := json.Unmarshal([]byte(tags), &frag.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &frag.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	frag.Created = time.Unix(0, created)
+	frag.Updated = time.Unix(0, updated)
+	if deleted.Valid {
+		deletedAt := time.Unix(0, deleted.Int64)
+		frag.Deleted = &deletedAt
+	}
+	return &frag, nil
+}
+
+func (store *SQLiteStore) WriteFragment(frag *Fragment) error {
+	_, err := store.db.Exec(`INSERT OR REPLACE INTO fragments (`+fragmentColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, fragmentValues(frag)...)
+	return err
+}
+
+func (store *SQLiteStore) CreateFragment(frag *Fragment) error {
+	result, err := store.db.Exec(`INSERT INTO fragments (`+fragmentColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`, fragmentValues(frag)...)
+	if err != nil {
+		return err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if inserted == 0 {
+		return ErrFragmentExists
+	}
+	return nil
+}
+
+func (store *SQLiteStore) ReplaceFragment(frag *Fragment, previous *Fragment) error {
+	args := append(fragmentValues(frag), previous.OwnerId, previous.Id, previous.Version, previous.MetadataRevision)
+	result, err := store.db.Exec(`UPDATE fragments SET (`+fragmentColumns+`)
+		= (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		WHERE owner_id = ? AND id = ? AND version = ? AND metadata_revision = ?`, args...)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return ErrFragmentChanged
+	}
+	return nil
+}
+
+// Returns:
+//
+//	nil, nil: No errors occured but a matching value was not found.
+func (store *SQLiteStore) GetFragment(ownerId string, id string) (*Fragment, error) {
+	frag, err := scanFragment(store.db.QueryRow(`SELECT `+fragmentColumns+`
+		FROM fragments WHERE owner_id = ? AND id = ?`, ownerId, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return frag, err
+}
+
+// Ids are looked up this many at a time to stay below SQLite's limit on query parameters
+const sqliteBatchSize = 500
+
+func (store *SQLiteStore) BatchGetFragments(ownerId string, ids []string) []FragmentResult {
+	results := make([]FragmentResult, len(ids))
+	for start := 0; start < len(ids); start += sqliteBatchSize {
+		batch := ids[start:min(start+sqliteBatchSize, len(ids))]
+		args := []any{ownerId}
+		for _, id := range batch {
+			args = append(args, id)
+		}
+		fragments, err := store.queryFragments(`SELECT `+fragmentColumns+` FROM fragments
+			WHERE owner_id = ? AND id IN (?`+strings.Repeat(", ?", len(batch)-1)+`)`, args...)
+		found := map[string]*Fragment{}
+		for i := range fragments {
+			found[fragments[i].Id] = &fragments[i]
+		}
+		for i, id := range batch {
+			results[start+i] = FragmentResult{found[id], err}
+		}
+	}
+	return results
+}
+
+// Returns the ids ordered the same way as the DynamoDB sort key.
+func (store *SQLiteStore) GetFragmentIds(ownerId string) ([]string, error) {
+	return store.queryStrings(`SELECT id FROM fragments WHERE owner_id = ? AND deleted IS NULL ORDER BY id`, ownerId)
+}
+
+// Reads a single page with a keyset query, the cursor is compared with the
+// sort column and id the rows are ordered by.
+func (store *SQLiteStore) ListFragments(ownerId string, options ListOptions) (*FragmentPage, error) {
+	column, ok := sqliteSortColumns[options.Sort]
+	if !ok {
+		column = sqliteSortColumns[SortCreated]
+	}
+	conditions := []string{"owner_id = ?", "deleted IS NULL"}
+	args := []any{ownerId}
+	condition := func(condition string, arg any) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if options.Type != "" {
+		condition(sqliteBaseType+" = ?", strings.ToLower(options.Type))
+	}
+	for _, tag := range options.Tags {
+		condition(`EXISTS (SELECT 1 FROM json_each(nullif(tags, '')) WHERE value = ?)`, tag)
+	}
+	if !options.CreatedAfter.IsZero() {
+		condition("created > ?", options.CreatedAfter.UnixNano())
+	}
+	if !options.CreatedBefore.IsZero() {
+		condition("created < ?", options.CreatedBefore.UnixNano())
+	}
+	if !options.UpdatedAfter.IsZero() {
+		condition("updated > ?", options.UpdatedAfter.UnixNano())
+	}
+	if !options.UpdatedBefore.IsZero() {
+		condition("updated < ?", options.UpdatedBefore.UnixNano())
+	}
+	if options.MinSize > 0 {
+		condition("size >= ?", options.MinSize)
+	}
+	if options.MaxSize > 0 {
+		condition("size <= ?", options.MaxSize)
+	}
+
+	order, comparison := "ASC", ">"
+	if options.Descending {
+		order, comparison = "DESC", "<"
+	}
+	if options.after != nil {
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (?, ?)", column, comparison))
+		args = append(args, options.after.Value, options.after.Id)
+	}
+	// One more row than the limit tells whether there is a next page, -1 reads every row
+	limit := -1
+	if options.Limit > 0 {
+		limit = options.Limit + 1
+	}
+	fragments, err := store.queryFragments(`SELECT `+fragmentColumns+` FROM fragments
+		WHERE `+strings.Join(conditions, " AND ")+
+		fmt.Sprintf(` ORDER BY %s %s, id %s LIMIT ?`, column, order, order), append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return options.page(fragments), nil
+}
+
+func (store *SQLiteStore) GetTrashedFragments(ownerId string) ([]Fragment, error) {
+	return store.queryFragments(`SELECT `+fragmentColumns+`
+		FROM fragments WHERE owner_id = ? AND deleted IS NOT NULL ORDER BY deleted`, ownerId)
+}
+
+func (store *SQLiteStore) GetOwnerIds() ([]string, error) {
+	return store.queryStrings(`SELECT DISTINCT owner_id FROM fragments ORDER BY owner_id`)
+}
+
+func (store *SQLiteStore) DeleteFragment(ownerId string, id string) error {
+	_, err := store.db.Exec(`DELETE FROM fragments WHERE owner_id = ? AND id = ?`, ownerId, id)
+	return err
+}
+
+func (store *SQLiteStore) WriteFragmentVersion(frag *Fragment) error {
+	result, err := store.db.Exec(`INSERT INTO fragment_versions (`+fragmentColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`, fragmentValues(frag)...)
+	if err != nil {
+		return err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if inserted == 0 {
+		return ErrVersionExists
+	}
+	return nil
+}
+
+func (store *SQLiteStore) GetFragmentVersion(ownerId string, id string, version int) (*Fragment, error) {
+	frag, err := scanFragment(store.db.QueryRow(`SELECT `+fragmentColumns+`
+		FROM fragment_versions WHERE owner_id = ? AND id = ? AND version = ?`, ownerId, id, version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return frag, err
+}
+
+func (store *SQLiteStore) GetFragmentVersions(ownerId string, id string) ([]Fragment, error) {
+	return store.queryFragments(`SELECT `+fragmentColumns+`
+		FROM fragment_versions WHERE owner_id = ? AND id = ? ORDER BY version`, ownerId, id)
+}
+
+func (store *SQLiteStore) DeleteFragmentVersion(ownerId string, id string, version int) error {
+	_, err := store.db.Exec(`DELETE FROM fragment_versions WHERE owner_id = ? AND id = ? AND version = ?`, ownerId, id, version)
+	return err
+}
+
+func (store *SQLiteStore) AddBlobRefs(ownerId string, key string, delta int) (int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Blobs being deleted are left alone, which returns no row
+	var refs int
+	err = tx.QueryRow(`INSERT INTO blob_refs (owner_id, data_key, refs) VALUES (?, ?, ?)
+		ON CONFLICT (owner_id, data_key) DO UPDATE SET refs = refs + excluded.refs WHERE refs <> ?
+		RETURNING refs`, ownerId, key, delta, blobDeleting).Scan(&refs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrBlobDeleting
+	}
+	if err != nil {
+		return 0, err
+	}
+	if refs <= 0 {
+		if _, err := tx.Exec(`DELETE FROM blob_refs WHERE owner_id = ? AND data_key = ?`, ownerId, key); err != nil {
+			return 0, err
+		}
+	}
+	return refs, tx.Commit()
+}
+
+func (store *SQLiteStore) GetBlobRefs(ownerId string, key string) (int, error) {
+	var refs int
+	err := store.db.QueryRow(`SELECT MAX(refs, 0) FROM blob_refs WHERE owner_id = ? AND data_key = ?`, ownerId, key).Scan(&refs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return refs, err
+}
+
+func (store *SQLiteStore) ClaimBlobDeletion(ownerId string, key string) (bool, error) {
+	result, err := store.db.Exec(`INSERT INTO blob_refs (owner_id, data_key, refs) VALUES (?, ?, ?)
+		ON CONFLICT (owner_id, data_key) DO NOTHING`, ownerId, key, blobDeleting)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	return claimed == 1, err
+}
+
+func (store *SQLiteStore) EndBlobDeletion(ownerId string, key string) error {
+	_, err := store.db.Exec(`DELETE FROM blob_refs WHERE owner_id = ? AND data_key = ? AND refs = ?`, ownerId, key, blobDeleting)
+	return err
+}
+
+func (store *SQLiteStore) WriteDataKey(key *DataKey) error {
+	_, err := store.db.Exec(`INSERT OR REPLACE INTO data_keys (owner_id, id, wrapped_key, master_key_id, created)
+		VALUES (?, ?, ?, ?, ?)`, key.OwnerId, key.Id, key.WrappedKey, key.MasterKeyId, key.Created.UnixNano())
+	return err
+}
+
+func (store *SQLiteStore) GetDataKeys(ownerId string) ([]DataKey, error) {
+	return store.queryDataKeys(`SELECT owner_id, id, wrapped_key, master_key_id, created
+		FROM data_keys WHERE owner_id = ? ORDER BY created`, ownerId)
+}
+
+func (store *SQLiteStore) ListDataKeys() ([]DataKey, error) {
+	return store.queryDataKeys(`SELECT owner_id, id, wrapped_key, master_key_id, created
+		FROM data_keys ORDER BY owner_id, created`)
+}
+
+func (store *SQLiteStore) CreateNameEntry(entry *NameEntry) error {
+	// The primary key makes the insert fail when the name is taken
+	result, err := store.db.Exec(`INSERT INTO name_entries (owner_id, parent_id, name, kind, id, created)
+		VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		entry.OwnerId, entry.ParentId, entry.Name, entry.Kind, entry.Id, entry.Created.UnixNano())
+	if err != nil {
+		return err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if inserted == 0 {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+func (store *SQLiteStore) GetNameEntry(ownerId string, parentId string, name string) (*NameEntry, error) {
+	entries, err := store.queryNameEntries(`SELECT owner_id, parent_id, name, kind, id, created
+		FROM name_entries WHERE owner_id = ? AND parent_id = ? AND name = ?`, ownerId, parentId, name)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return &entries[0], nil
+}
+
+func (store *SQLiteStore) ListNameEntries(ownerId string) ([]NameEntry, error) {
+	return store.queryNameEntries(`SELECT owner_id, parent_id, name, kind, id, created
+		FROM name_entries WHERE owner_id = ?`, ownerId)
+}
+
+func (store *SQLiteStore) DeleteNameEntry(ownerId string, parentId string, name string) error {
+	_, err := store.db.Exec(`DELETE FROM name_entries WHERE owner_id = ? AND parent_id = ? AND name = ?`, ownerId, parentId, name)
+	return err
+}
+
+func (store *SQLiteStore) queryNameEntries(query string, args ...any) ([]NameEntry, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []NameEntry{}
+	for rows.Next() {
+		var entry NameEntry
+		var created int64
+		if err := rows.Scan(&entry.OwnerId, &entry.ParentId, &entry.Name, &entry.Kind, &entry.Id, &created); err != nil {
+			return nil, err
+		}
+		entry.Created = time.Unix(0, created)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (store *SQLiteStore) queryDataKeys(query string, args ...any) ([]DataKey, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []DataKey{}
+	for rows.Next() {
+		var key DataKey
+		var created int64
+		if err := rows.Scan(&key.OwnerId, &key.Id, &key.WrappedKey, &key.MasterKeyId, &created); err != nil {
+			return nil, err
+		}
+		key.Created = time.Unix(0, created)
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (store *SQLiteStore) queryFragments(query string, args ...any) ([]Fragment, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fragments := []Fragment{}
+	for rows.Next() {
+		frag, err := scanFragment(rows)
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, *frag)
+	}
+	return fragments, rows.Err()
+}
+
+func (store *SQLiteStore) queryStrings(query string, args ...any) ([]string, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
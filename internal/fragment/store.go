@@ -0,0 +1,205 @@
+package fragment
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+)
+
+// FragmentStore persists fragment metadata.
+type FragmentStore interface {
+	WriteFragment(frag *Fragment) error
+	// Like WriteFragment but returns ErrFragmentExists instead of overwriting
+	// a fragment of the owner with the same id.
+	CreateFragment(frag *Fragment) error
+	// Like WriteFragment but only replaces the fragment while it still has the
+	// Version and MetadataRevision of previous. Returns ErrFragmentChanged
+	// otherwise, including when the fragment no longer exists.
+	ReplaceFragment(frag *Fragment, previous *Fragment) error
+	// Returns nil, nil when no fragment matches the owner and id.
+	GetFragment(ownerId string, id string) (*Fragment, error)
+	// Reads several of the owner's fragments at once. Returns one result per id
+	// in the same order, with neither a fragment nor an error for missing ones.
+	BatchGetFragments(ownerId string, ids []string) []FragmentResult
+	// Returns the ids of the owner's fragments that are not in the trash.
+	GetFragmentIds(ownerId string) ([]string, error)
+	// Returns a page of the owner's fragments that are not in the trash and
+	// match the options, starting after their cursor. Only the fields needed to
+	// filter and sort listings are guaranteed to be set: Id, OwnerId, Created,
+	// Updated, FragmentType, Size and Tags.
+	ListFragments(ownerId string, options ListOptions) (*FragmentPage, error)
+	// Returns the owner's fragments that are in the trash.
+	GetTrashedFragments(ownerId string) ([]Fragment, error)
+	DeleteFragment(ownerId string, id string) error
+	// Returns every owner that has at least one fragment, trashed or not.
+	GetOwnerIds() ([]string, error)
+
+	// Versions are immutable snapshots of a fragment's metadata, keyed by Fragment.Version.
+	// Returns ErrVersionExists instead of overwriting a version.
+	WriteFragmentVersion(frag *Fragment) error
+	// Returns nil, nil when the version doesn't exist.
+	GetFragmentVersion(ownerId string, id string, version int) (*Fragment, error)
+	// Returns the versions ordered from oldest to newest.
+	GetFragmentVersions(ownerId string, id string) ([]Fragment, error)
+	DeleteFragmentVersion(ownerId string, id string, version int) error
+
+	// Reference counts of deduplicated blobs, keyed by the owner namespace and data key of the blob.
+	// Adds delta to the count and returns the new count. Counts that drop to 0 are removed.
+	// Returns ErrBlobDeleting while the blob is being deleted.
+	AddBlobRefs(ownerId string, key string, delta int) (int, error)
+	// Returns 0 when the blob isn't referenced or is being deleted.
+	GetBlobRefs(ownerId string, key string) (int, error)
+	// Marks an unreferenced blob as being deleted, which keeps references from
+	// being added until EndBlobDeletion. Returns false when the blob is
+	// referenced or already being deleted.
+	ClaimBlobDeletion(ownerId string, key string) (bool, error)
+	EndBlobDeletion(ownerId string, key string) error
+
+	// Wrapped data keys used to encrypt fragment data, keyed by owner namespace and id.
+	WriteDataKey(key *DataKey) error
+	// Returns the keys of the owner namespace ordered from oldest to newest.
+	GetDataKeys(ownerId string) ([]DataKey, error)
+	// Returns the keys of every owner namespace. Only meant for key rotation.
+	ListDataKeys() ([]DataKey, error)
+
+	// Names of the owner's fragments and collections, keyed by owner, parent collection and name.
+	// Returns ErrNameTaken when the parent already holds an entry with the name.
+	CreateNameEntry(entry *NameEntry) error
+	// Returns nil, nil when the parent holds no entry with the name.
+	GetNameEntry(ownerId string, parentId string, name string) (*NameEntry, error)
+	// Returns every entry of the owner, in no particular order.
+	ListNameEntries(ownerId string) ([]NameEntry, error)
+	DeleteNameEntry(ownerId string, parentId string, name string) error
+}
+
+// BlobStore persists the raw data of fragments.
+type BlobStore interface {
+	// Stores everything read from data and returns the number of bytes written.
+	WriteFragmentData(ownerId string, id string, data io.Reader) (int64, error)
+	// Returns ErrFragmentDataNotFound when there is no data for the owner and id.
+	// The caller must close the returned reader.
+	ReadFragmentData(ownerId string, id string) (io.ReadCloser, error)
+	// Returns length bytes of the data starting at offset. The range must lie
+	// within the data.
+	ReadFragmentDataRange(ownerId string, id string, offset int64, length int64) (io.ReadCloser, error)
+	DeleteFragmentData(ownerId string, id string) error
+	HasFragmentData(ownerId string, id string) (bool, error)
+	// Copies a blob, possibly into the namespace of another owner.
+	CopyFragmentData(srcOwnerId string, srcId string, dstOwnerId string, dstId string) error
+	// Returns every owner that has at least one stored blob.
+	ListDataOwnerIds() ([]string, error)
+	ListFragmentData(ownerId string) ([]BlobInfo, error)
+}
+
+// Outcome of reading one fragment of a batch.
+type FragmentResult struct {
+	Fragment *Fragment
+	Err      error
+}
+
+// BlobInfo describes a stored blob without its contents.
+type BlobInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+var storesMu sync.RWMutex
+var fragmentStore FragmentStore
+var blobStore BlobStore
+
+// Replaces the backends used by the package level fragment functions.
+func SetStores(metadata FragmentStore, data BlobStore) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	fragmentStore = metadata
+	blobStore = data
+	clearDataKeyCache()
+}
+
+// Selects the metadata and data backends named in the configuration.
+func InitStores() error {
+	metadata, err := NewFragmentStore(config.MetadataBackend)
+	if err != nil {
+		return err
+	}
+	data, err := NewBlobStore(config.DataBackend)
+	if err != nil {
+		return err
+	}
+	SetStores(metadata, data)
+	return nil
+}
+
+func NewFragmentStore(backend string) (FragmentStore, error) {
+	switch backend {
+	case "", "dynamodb":
+		client, err := GetDynamoDBClient()
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return NewSQLiteStore(config.SQLitePath)
+	}
+	return nil, fmt.Errorf("unknown metadata backend: %s", backend)
+}
+
+func NewBlobStore(backend string) (BlobStore, error) {
+	switch backend {
+	case "", "s3":
+		client, err := GetS3Client()
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case "memory":
+		return NewMemoryStore(), nil
+	case "filesystem":
+		return NewFileSystemStore(config.DataDir, config.DataFsync)
+	}
+	return nil, fmt.Errorf("unknown data backend: %s", backend)
+}
+
+// Returns the configured stores, creating them on first use.
+func getStores() (FragmentStore, BlobStore, error) {
+	storesMu.RLock()
+	metadata, data := fragmentStore, blobStore
+	storesMu.RUnlock()
+	if metadata != nil && data != nil {
+		return metadata, data, nil
+	}
+
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	if fragmentStore == nil {
+		store, err := NewFragmentStore(config.MetadataBackend)
+		if err != nil {
+			return nil, nil, err
+		}
+		fragmentStore = store
+	}
+	if blobStore == nil {
+		store, err := NewBlobStore(config.DataBackend)
+		if err != nil {
+			return nil, nil, err
+		}
+		blobStore = store
+	}
+	return fragmentStore, blobStore, nil
+}
+
+func getFragmentStore() (FragmentStore, error) {
+	metadata, _, err := getStores()
+	return metadata, err
+}
+
+func getBlobStore() (BlobStore, error) {
+	_, data, err := getStores()
+	return data, err
+}
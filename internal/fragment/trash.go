@@ -0,0 +1,168 @@
+package fragment
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+var ErrFragmentInTrash = errors.New("fragment is in the trash")
+
+// Moves the fragment into its owner's trash. Its metadata, versions and data
+// are kept until the fragment is restored or purged.
+//
+// Returns nil, nil when the fragment doesn't exist or is already in the trash.
+func TrashFragment(username string, fragment_id string) (*Fragment, error) {
+	return TrashFragmentIf(username, fragment_id, nil)
+}
+
+// Like TrashFragment but returns ErrPreconditionFailed unless the precondition
+// holds for the fragment that is moved to the trash.
+func TrashFragmentIf(username string, fragment_id string, precondition Precondition) (*Fragment, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	frag, err := retryChanged(username, fragment_id, func() (*Fragment, error) {
+		current, err := GetFragment(username, fragment_id)
+		if err != nil {
+			return nil, err
+		}
+		if precondition != nil && !precondition(current) {
+			return nil, ErrPreconditionFailed
+		}
+		if current == nil {
+			return nil, nil
+		}
+		trashed := *current
+		deleted := time.Now()
+		trashed.Deleted = &deleted
+		trashed.MetadataRevision++
+		if err := store.ReplaceFragment(&trashed, current); err != nil {
+			return nil, err
+		}
+		return &trashed, nil
+	})
+	if err != nil || frag == nil {
+		return nil, err
+	}
+	removeFromSearchIndex(username, fragment_id)
+	return frag, nil
+}
+
+// Returns the fragments in the user's trash.
+func GetTrashedFragments(username string) ([]Fragment, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetTrashedFragments(username)
+}
+
+// Takes the fragment out of the trash.
+//
+// Returns nil, nil when the fragment isn't in the trash.
+func RestoreFragment(username string, fragment_id string) (*Fragment, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	frag, err := retryChanged(username, fragment_id, func() (*Fragment, error) {
+		current, err := ReadFragment(username, fragment_id)
+		if err != nil || current == nil || current.Deleted == nil {
+			return nil, err
+		}
+		restored := *current
+		restored.Deleted = nil
+		restored.MetadataRevision++
+		if err := store.ReplaceFragment(&restored, current); err != nil {
+			return nil, err
+		}
+		return &restored, nil
+	})
+	if err != nil || frag == nil {
+		return nil, err
+	}
+	if err := reindexFragment(frag); err != nil {
+		logger.Sugar.Warnf("Failed to index restored fragment for userid: %s and fragment_id: %s", username, fragment_id)
+		logger.Sugar.Warn(err)
+	}
+	return frag, nil
+}
+
+// Permanently deletes a fragment from the trash.
+//
+// Returns false, nil when the fragment isn't in the trash.
+func PurgeFragment(username string, fragment_id string) (bool, error) {
+	frag, err := ReadFragment(username, fragment_id)
+	if err != nil || frag == nil || frag.Deleted == nil {
+		return false, err
+	}
+	if !DeleteFragmentDB(username, fragment_id) {
+		return false, errors.New("failed to delete the fragment")
+	}
+	return true, nil
+}
+
+// Permanently deletes every fragment that has been in the trash for longer
+// than the retention period and returns how many were deleted.
+func PurgeExpiredTrash(retention time.Duration) (int, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return 0, err
+	}
+	ownerIds, err := store.GetOwnerIds()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, ownerId := range ownerIds {
+		trashed, err := store.GetTrashedFragments(ownerId)
+		if err != nil {
+			return purged, err
+		}
+		for _, frag := range trashed {
+			if time.Since(*frag.Deleted) < retention {
+				continue
+			}
+			// Purging checks again that the fragment wasn't restored in the meantime
+			ok, err := PurgeFragment(ownerId, frag.Id)
+			if err != nil {
+				logger.Sugar.Warnf("Failed to purge trashed fragment for userid: %s and fragment_id: %s", ownerId, frag.Id)
+				logger.Sugar.Warn(err)
+				continue
+			}
+			if ok {
+				purged++
+			}
+		}
+	}
+	return purged, nil
+}
+
+// Runs PurgeExpiredTrash every interval until the returned function is called.
+func StartTrashPurger(interval time.Duration, retention time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				purged, err := PurgeExpiredTrash(retention)
+				if err != nil {
+					logger.Sugar.Error("Purging the trash failed: ", err)
+				}
+				if purged > 0 {
+					logger.Sugar.Infof("Purged %d fragments from the trash", purged)
+				}
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
@@ -0,0 +1,219 @@
+package fragment
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+var ErrVersionNotFound = errors.New("fragment version not found")
+var ErrVersionExists = errors.New("fragment version already exists")
+
+// Number of times a write is built again on top of the current metadata when
+// other writes keep committing first
+const maxCommitAttempts = 5
+
+// Records committed as the next version after previous and makes it the
+// current metadata of the fragment. previous is nil for new fragments.
+//
+// The version is written before the fragment so that the current version is
+// always part of the history. It is removed again if the fragment can't be
+// written. New fragments are the exception: they are created first, so a
+// collision can't overwrite the other fragment's versions either.
+//
+// Versions are never overwritten and the fragment is only replaced while it is
+// still at previous, so of two writes committed on top of the same previous
+// one fails with ErrFragmentChanged. That includes two writes creating the
+// same fragment.
+func commitVersion(previous *Fragment, committed *Fragment) error {
+	store, err := getFragmentStore()
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		committed.Version = 1
+		if err := store.CreateFragment(committed); errors.Is(err, ErrFragmentExists) {
+			return ErrFragmentChanged
+		} else if err != nil {
+			return err
+		}
+		if err := store.WriteFragmentVersion(committed); err != nil {
+			if deleteErr := store.DeleteFragment(committed.OwnerId, committed.Id); deleteErr != nil {
+				logger.Sugar.Errorf("Failed to remove the fragment without a version for userid: %s and fragment_id: %s", committed.OwnerId, committed.Id)
+				logger.Sugar.Error(deleteErr)
+			}
+			return err
+		}
+		return nil
+	}
+
+	committed.Created = previous.Created
+	committed.Name = previous.Name
+	committed.CollectionId = previous.CollectionId
+	committed.MetadataRevision = previous.MetadataRevision
+	if committed.Tags == nil {
+		committed.Tags = previous.Tags
+	}
+	if committed.Metadata == nil {
+		committed.Metadata = previous.Metadata
+	}
+	committed.Version = previous.Version + 1
+	if previous.Version == 0 {
+		// Fragments written before versioning keep their current state as the first version.
+		// A concurrent write may have recorded the same state already.
+		legacy := *previous
+		legacy.Version = 1
+		if err := store.WriteFragmentVersion(&legacy); err != nil && !errors.Is(err, ErrVersionExists) {
+			return err
+		}
+		committed.Version = 2
+	}
+
+	if err := store.WriteFragmentVersion(committed); errors.Is(err, ErrVersionExists) {
+		return ErrFragmentChanged
+	} else if err != nil {
+		return err
+	}
+	if err := store.ReplaceFragment(committed, previous); err != nil {
+		if deleteErr := store.DeleteFragmentVersion(committed.OwnerId, committed.Id, committed.Version); deleteErr != nil {
+			logger.Sugar.Errorf("Failed to remove uncommitted version %d for userid: %s and fragment_id: %s", committed.Version, committed.OwnerId, committed.Id)
+			logger.Sugar.Error(deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Commits the fragment that next builds on top of previous as the fragment's
+// next version. When another write commits first, previous is read again and
+// next is called again to build on top of it, up to maxCommitAttempts times.
+// Errors returned by next are returned as is.
+func commitNext(ownerId string, id string, previous *Fragment, next func(previous *Fragment) (*Fragment, error)) (*Fragment, error) {
+	for attempt := 1; ; attempt++ {
+		committed, err := next(previous)
+		if err != nil {
+			return nil, err
+		}
+		err = commitVersion(previous, committed)
+		if err == nil {
+			return committed, nil
+		}
+		if !errors.Is(err, ErrFragmentChanged) || attempt == maxCommitAttempts {
+			return nil, err
+		}
+		logger.Sugar.Infof("Fragment changed while committing, retrying for userid: %s and fragment_id: %s", ownerId, id)
+		if previous, err = ReadFragment(ownerId, id); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Calls write, which reads the fragment and replaces it with ReplaceFragment,
+// until it doesn't fail with ErrFragmentChanged, up to maxCommitAttempts times.
+func retryChanged(ownerId string, id string, write func() (*Fragment, error)) (*Fragment, error) {
+	for attempt := 1; ; attempt++ {
+		frag, err := write()
+		if !errors.Is(err, ErrFragmentChanged) || attempt == maxCommitAttempts {
+			return frag, err
+		}
+		logger.Sugar.Infof("Fragment changed while writing, retrying for userid: %s and fragment_id: %s", ownerId, id)
+	}
+}
+
+// Returns every version of the fragment, oldest first.
+func GetFragmentVersions(username string, fragment_id string) ([]Fragment, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetFragmentVersions(username, fragment_id)
+}
+
+// Returns nil, nil when the version doesn't exist.
+func GetFragmentVersion(username string, fragment_id string, version int) (*Fragment, error) {
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetFragmentVersion(username, fragment_id, version)
+}
+
+// Makes the data and type of a previous version current again by committing
+// them as a new version, so the rollback itself can be undone.
+//
+// Returns nil, nil when the fragment doesn't exist or is in the trash and
+// ErrVersionNotFound when the version doesn't.
+func RollbackFragment(username string, fragment_id string, version int) (*Fragment, error) {
+	current, err := GetFragment(username, fragment_id)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	target, err := GetFragmentVersion(username, fragment_id, version)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, ErrVersionNotFound
+	}
+
+	store, err := getFragmentStore()
+	if err != nil {
+		return nil, err
+	}
+	shared := isContentKey(target.dataKey())
+	if shared {
+		// The new version holds its own reference to the deduplicated blob
+		if _, err := store.AddBlobRefs(target.dataOwnerId(), target.dataKey(), 1); err != nil {
+			return nil, err
+		}
+	}
+	committed, err := commitNext(username, fragment_id, current, func(current *Fragment) (*Fragment, error) {
+		if current == nil || current.Deleted != nil {
+			return nil, ErrFragmentChanged
+		}
+		// Blobs are immutable so the new version can share the old version's blob
+		committed := *current
+		committed.DataKey = target.dataKey()
+		committed.DataOwnerId = target.DataOwnerId
+		committed.Size = target.Size
+		committed.StoredSize = target.StoredSize
+		committed.Encoding = target.Encoding
+		committed.KeyId = target.KeyId
+		committed.Hash = target.Hash
+		committed.FragmentType = target.FragmentType
+		committed.Updated = time.Now()
+		committed.Broken = false
+		return &committed, nil
+	})
+	if err != nil {
+		if shared {
+			releaseUncommittedData(store, target)
+		}
+		return nil, err
+	}
+	if err := reindexFragment(committed); err != nil {
+		logger.Sugar.Warnf("Failed to index fragment for userid: %s and fragment_id: %s", username, fragment_id)
+		logger.Sugar.Warn(err)
+	}
+	return committed, nil
+}
+
+// Returns the distinct data keys of the blobs owned by the fragment and its
+// versions. Deduplicated blobs are left out since they are shared through
+// reference counts.
+func fragmentDataKeys(store FragmentStore, frag *Fragment) ([]string, error) {
+	versions, err := store.GetFragmentVersions(frag.OwnerId, frag.Id)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, version := range append([]Fragment{*frag}, versions...) {
+		if !seen[version.dataKey()] && !isContentKey(version.dataKey()) {
+			seen[version.dataKey()] = true
+			keys = append(keys, version.dataKey())
+		}
+	}
+	return keys, nil
+}
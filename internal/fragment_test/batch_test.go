@@ -0,0 +1,34 @@
+package fragment_test
+
+import (
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFragments(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	store := testutils.SetupMemoryStore(t)
+	for _, id := range []string{"a", "b", "trashed"} {
+		frag := testutils.CreateTestFragment()
+		frag.Id = id
+		if id == "trashed" {
+			frag.Deleted = &frag.Updated
+		}
+		store.WriteFragment(&frag)
+	}
+
+	results := fragment.GetFragments("user", []string{"b", "missing", "a", "trashed"})
+	assert.Equal(t, 4, len(results))
+	assert.Equal(t, "b", results[0].Fragment.Id)
+	assert.Nil(t, results[1].Fragment)
+	assert.Equal(t, "a", results[2].Fragment.Id)
+	assert.Nil(t, results[3].Fragment)
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+	}
+}
@@ -0,0 +1,156 @@
+package fragment_test
+
+import (
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func collectionPaths(t *testing.T, ownerId string) []string {
+	collections, err := fragment.GetCollections(ownerId)
+	assert.NoError(t, err)
+	paths := []string{}
+	for _, collection := range collections {
+		paths = append(paths, collection.Path)
+	}
+	return paths
+}
+
+func nameFragment(t *testing.T, id string, collectionId string, name string) (*fragment.Fragment, error) {
+	frag := testutils.CreateTestFragment()
+	frag.Id = id
+	if existing, _ := fragment.GetFragment("user", id); existing == nil {
+		assert.NoError(t, frag.SetData([]byte("Sample data "+id)))
+	}
+	return fragment.UpdateFragmentMetadata("user", id, fragment.MetadataUpdate{Name: &name, CollectionId: &collectionId})
+}
+
+func TestCollections(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestCreateCollections", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		work, err := fragment.CreateCollection("user", "", "work")
+		assert.NoError(t, err)
+		assert.Equal(t, "/work", work.Path)
+		notes, err := fragment.CreateCollection("user", work.Id, "notes")
+		assert.NoError(t, err)
+		assert.Equal(t, "/work/notes", notes.Path)
+		fragment.CreateCollection("other", "", "home")
+
+		_, err = fragment.CreateCollection("user", "", "work")
+		assert.ErrorIs(t, err, fragment.ErrNameTaken)
+		_, err = fragment.CreateCollection("user", "missing", "notes")
+		assert.ErrorIs(t, err, fragment.ErrCollectionNotFound)
+		for _, name := range []string{"", ".", "..", "a/b", "tab\t"} {
+			_, err = fragment.CreateCollection("user", "", name)
+			assert.ErrorIs(t, err, fragment.ErrInvalidName, name)
+		}
+		assert.Equal(t, []string{"/work", "/work/notes"}, collectionPaths(t, "user"))
+	})
+
+	t.Run("TestMoveCollection", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		work, _ := fragment.CreateCollection("user", "", "work")
+		notes, _ := fragment.CreateCollection("user", work.Id, "notes")
+		archive, _ := fragment.CreateCollection("user", "", "archive")
+
+		moved, err := fragment.MoveCollection("user", work.Id, archive.Id, "old-work")
+		assert.NoError(t, err)
+		assert.Equal(t, "/archive/old-work", moved.Path)
+		assert.Equal(t, []string{"/archive", "/archive/old-work", "/archive/old-work/notes"}, collectionPaths(t, "user"))
+
+		_, err = fragment.MoveCollection("user", archive.Id, notes.Id, "archive")
+		assert.ErrorIs(t, err, fragment.ErrCollectionCycle)
+		_, err = fragment.MoveCollection("user", archive.Id, archive.Id, "archive")
+		assert.ErrorIs(t, err, fragment.ErrCollectionCycle)
+		fragment.CreateCollection("user", "", "taken")
+		_, err = fragment.MoveCollection("user", archive.Id, "", "taken")
+		assert.ErrorIs(t, err, fragment.ErrNameTaken)
+		_, err = fragment.MoveCollection("user", "missing", "", "name")
+		assert.ErrorIs(t, err, fragment.ErrCollectionNotFound)
+	})
+
+	t.Run("TestNameFragments", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		work, _ := fragment.CreateCollection("user", "", "work")
+		frag, err := nameFragment(t, "a", work.Id, "todo")
+		assert.NoError(t, err)
+		assert.Equal(t, "todo", frag.Name)
+		assert.Equal(t, work.Id, frag.CollectionId)
+
+		found, err := fragment.GetFragmentByPath("user", "/work/todo")
+		assert.NoError(t, err)
+		assert.Equal(t, "a", found.Id)
+		for _, path := range []string{"/work", "/todo", "/work/todo/more", "/", "/other/todo"} {
+			found, err := fragment.GetFragmentByPath("user", path)
+			assert.NoError(t, err)
+			assert.Nil(t, found, path)
+		}
+
+		// Names are unique among fragments and collections alike
+		_, err = nameFragment(t, "b", work.Id, "todo")
+		assert.ErrorIs(t, err, fragment.ErrNameTaken)
+		_, err = nameFragment(t, "b", "", "work")
+		assert.ErrorIs(t, err, fragment.ErrNameTaken)
+		_, err = nameFragment(t, "b", work.Id, "")
+		assert.ErrorIs(t, err, fragment.ErrInvalidName)
+		_, err = nameFragment(t, "b", "missing", "todo")
+		assert.ErrorIs(t, err, fragment.ErrCollectionNotFound)
+
+		// Renaming releases the previous name
+		_, err = nameFragment(t, "a", "", "todo")
+		assert.NoError(t, err)
+		_, err = nameFragment(t, "b", work.Id, "todo")
+		assert.NoError(t, err)
+		listing, err := fragment.ListCollection("user", work.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"b"}, listing.FragmentIds)
+		listing, _ = fragment.ListCollection("user", "")
+		assert.Equal(t, []string{"a"}, listing.FragmentIds)
+		assert.Equal(t, "work", listing.Collections[0].Name)
+	})
+
+	t.Run("TestNamesFollowFragments", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		work, _ := fragment.CreateCollection("user", "", "work")
+		nameFragment(t, "a", work.Id, "todo")
+
+		// Writes keep the name
+		update := testutils.CreateTestFragment()
+		update.Id = "a"
+		assert.NoError(t, update.SetData([]byte("New data")))
+		assert.Equal(t, "todo", update.Name)
+		found, _ := fragment.GetFragmentByPath("user", "/work/todo")
+		assert.Equal(t, 2, found.Version)
+
+		fragment.TrashFragment("user", "a")
+		found, _ = fragment.GetFragmentByPath("user", "/work/todo")
+		assert.Nil(t, found)
+		_, err := fragment.DeleteCollection("user", work.Id)
+		assert.ErrorIs(t, err, fragment.ErrCollectionNotEmpty)
+
+		fragment.PurgeFragment("user", "a")
+		deleted, err := fragment.DeleteCollection("user", work.Id)
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		deleted, err = fragment.DeleteCollection("user", work.Id)
+		assert.NoError(t, err)
+		assert.False(t, deleted)
+	})
+
+	t.Run("TestRemoveName", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		nameFragment(t, "a", "", "todo")
+		frag, err := nameFragment(t, "a", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "", frag.Name)
+		found, _ := fragment.GetFragmentByPath("user", "/todo")
+		assert.Nil(t, found)
+		_, err = nameFragment(t, "b", "", "todo")
+		assert.NoError(t, err)
+	})
+}
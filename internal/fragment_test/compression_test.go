@@ -0,0 +1,94 @@
+package fragment_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func setCompression(t *testing.T, encoding string) {
+	previous := config.Compression
+	config.Compression = encoding
+	t.Cleanup(func() { config.Compression = previous })
+}
+
+func TestCompression(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+	data := []byte(strings.Repeat("All work and no play makes Jack a dull boy. ", 100))
+
+	for _, encoding := range []string{fragment.EncodingGzip, fragment.EncodingZstd} {
+		t.Run("TestRoundTrip"+encoding, func(t *testing.T) {
+			store := testutils.SetupMemoryStore(t)
+			setCompression(t, encoding)
+			frag := testutils.CreateTestFragment()
+			assert.NoError(t, frag.SetData(data))
+
+			assert.Equal(t, encoding, frag.Encoding)
+			assert.Equal(t, len(data), frag.Size)
+			assert.Less(t, frag.StoredSize, frag.Size)
+			stored, _ := testutils.ReadBlob(store, frag.OwnerId, frag.DataKey)
+			assert.Equal(t, frag.StoredSize, len(stored))
+
+			retrievedData, err := frag.GetData()
+			assert.NoError(t, err)
+			assert.Equal(t, data, retrievedData)
+
+			reader, err := frag.OpenDataRange(4, 4)
+			assert.NoError(t, err)
+			part, _ := io.ReadAll(reader)
+			reader.Close()
+			assert.Equal(t, "work", string(part))
+
+			// The stored size matches the blob, so nothing needs to be repaired
+			report, err := fragment.Reconcile(fragment.ReconcileOptions{})
+			assert.NoError(t, err)
+			assert.Empty(t, report.Discrepancies)
+		})
+	}
+
+	t.Run("TestUncompressedDataStaysReadable", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData(data))
+		assert.Equal(t, "", frag.Encoding)
+		assert.Equal(t, frag.Size, frag.StoredSize)
+
+		setCompression(t, fragment.EncodingGzip)
+		retrieved, _ := fragment.GetFragment(frag.OwnerId, frag.Id)
+		retrievedData, err := retrieved.GetData()
+		assert.NoError(t, err)
+		assert.Equal(t, data, retrievedData)
+
+		// Versions written before keep their encoding when rolled back to
+		assert.NoError(t, frag.SetData([]byte("Compressed")))
+		assert.Equal(t, fragment.EncodingGzip, frag.Encoding)
+		rolledBack, err := fragment.RollbackFragment(frag.OwnerId, frag.Id, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "", rolledBack.Encoding)
+		retrievedData, _ = rolledBack.GetData()
+		assert.Equal(t, data, retrievedData)
+	})
+
+	t.Run("TestDedupKeepsEncodingsApart", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		setDedup(t, "owner")
+		first := testutils.CreateTestFragment()
+		assert.NoError(t, first.SetData(data))
+		setCompression(t, fragment.EncodingZstd)
+		second := testutils.CreateTestFragment()
+		second.Id = "2"
+		assert.NoError(t, second.SetData(data))
+
+		assert.NotEqual(t, first.DataKey, second.DataKey)
+		retrievedData, _ := first.GetData()
+		assert.Equal(t, data, retrievedData)
+		retrievedData, _ = second.GetData()
+		assert.Equal(t, data, retrievedData)
+	})
+}
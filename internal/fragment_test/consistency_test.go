@@ -0,0 +1,232 @@
+package fragment_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+var errInjected = errors.New("injected failure")
+
+// Wraps the in-memory store and fails the operations that are switched on
+type faultyStore struct {
+	*fragment.MemoryStore
+	failWriteFragment        bool
+	failWriteFragmentVersion bool
+	failDeleteFragment       bool
+	failWriteFragmentData    bool
+	failDeleteFragmentData   bool
+	failCopyFragmentData     bool
+	dataKeys                 map[string]bool
+}
+
+func newFaultyStore() *faultyStore {
+	return &faultyStore{MemoryStore: fragment.NewMemoryStore(), dataKeys: map[string]bool{}}
+}
+
+func (store *faultyStore) WriteFragment(frag *fragment.Fragment) error {
+	if store.failWriteFragment {
+		return errInjected
+	}
+	return store.MemoryStore.WriteFragment(frag)
+}
+
+func (store *faultyStore) ReplaceFragment(frag *fragment.Fragment, previous *fragment.Fragment) error {
+	if store.failWriteFragment {
+		return errInjected
+	}
+	return store.MemoryStore.ReplaceFragment(frag, previous)
+}
+
+// Creates count as fragment writes
+func (store *faultyStore) CreateFragment(frag *fragment.Fragment) error {
+	if store.failWriteFragment {
+		return errInjected
+	}
+	return store.MemoryStore.CreateFragment(frag)
+}
+
+func (store *faultyStore) WriteFragmentVersion(frag *fragment.Fragment) error {
+	if store.failWriteFragmentVersion {
+		return errInjected
+	}
+	return store.MemoryStore.WriteFragmentVersion(frag)
+}
+
+func (store *faultyStore) DeleteFragment(ownerId string, id string) error {
+	if store.failDeleteFragment {
+		return errInjected
+	}
+	return store.MemoryStore.DeleteFragment(ownerId, id)
+}
+
+func (store *faultyStore) WriteFragmentData(ownerId string, id string, data io.Reader) (int64, error) {
+	if store.failWriteFragmentData {
+		return 0, errInjected
+	}
+	store.dataKeys[id] = true
+	return store.MemoryStore.WriteFragmentData(ownerId, id, data)
+}
+
+func (store *faultyStore) DeleteFragmentData(ownerId string, id string) error {
+	if store.failDeleteFragmentData {
+		return errInjected
+	}
+	delete(store.dataKeys, id)
+	return store.MemoryStore.DeleteFragmentData(ownerId, id)
+}
+
+func (store *faultyStore) CopyFragmentData(srcOwnerId string, srcId string, dstOwnerId string, dstId string) error {
+	if store.failCopyFragmentData {
+		return errInjected
+	}
+	return store.MemoryStore.CopyFragmentData(srcOwnerId, srcId, dstOwnerId, dstId)
+}
+
+// Wraps the in-memory store and runs interleave right before the next
+// conditional fragment write, as if another request got there first
+type interleavingStore struct {
+	*fragment.MemoryStore
+	interleave func()
+}
+
+func (store *interleavingStore) runInterleave() {
+	if interleave := store.interleave; interleave != nil {
+		store.interleave = nil
+		interleave()
+	}
+}
+
+func (store *interleavingStore) CreateFragment(frag *fragment.Fragment) error {
+	store.runInterleave()
+	return store.MemoryStore.CreateFragment(frag)
+}
+
+func (store *interleavingStore) ReplaceFragment(frag *fragment.Fragment, previous *fragment.Fragment) error {
+	store.runInterleave()
+	return store.MemoryStore.ReplaceFragment(frag, previous)
+}
+
+func setupInterleavingStore(t *testing.T) *interleavingStore {
+	store := &interleavingStore{MemoryStore: fragment.NewMemoryStore()}
+	fragment.SetStores(store, store)
+	t.Cleanup(fragment.ResetDB)
+	return store
+}
+
+func setupFaultyStore(t *testing.T) *faultyStore {
+	store := newFaultyStore()
+	fragment.SetStores(store, store)
+	t.Cleanup(fragment.ResetDB)
+	return store
+}
+
+// Asserts that the stored fragment's metadata matches its data
+func assertConsistent(t *testing.T, ownerId string, id string, expected []byte) {
+	frag, err := fragment.GetFragment(ownerId, id)
+	assert.NoError(t, err)
+	if expected == nil {
+		assert.Nil(t, frag)
+		return
+	}
+	if !assert.NotNil(t, frag) {
+		return
+	}
+	data, err := frag.GetData()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, data)
+	assert.Equal(t, len(expected), frag.Size)
+}
+
+func TestConsistentWrites(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestCreateDataWriteFails", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		store.failWriteFragmentData = true
+		frag := testutils.CreateTestFragment()
+		assert.ErrorIs(t, frag.SetData([]byte("Sample data")), errInjected)
+		assertConsistent(t, frag.OwnerId, frag.Id, nil)
+	})
+
+	t.Run("TestCreateMetadataWriteFails", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		store.failWriteFragment = true
+		frag := testutils.CreateTestFragment()
+		assert.ErrorIs(t, frag.SetData([]byte("Sample data")), errInjected)
+		assertConsistent(t, frag.OwnerId, frag.Id, nil)
+		// The uploaded data was cleaned up
+		assert.Empty(t, store.dataKeys)
+	})
+
+	t.Run("TestUpdateDataWriteFails", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Original")))
+		store.failWriteFragmentData = true
+		assert.ErrorIs(t, frag.SetData([]byte("Updated data")), errInjected)
+		assertConsistent(t, frag.OwnerId, frag.Id, []byte("Original"))
+	})
+
+	t.Run("TestUpdateMetadataWriteFails", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Original")))
+		store.failWriteFragment = true
+		assert.ErrorIs(t, frag.SetData([]byte("Updated data")), errInjected)
+		assertConsistent(t, frag.OwnerId, frag.Id, []byte("Original"))
+		assert.Equal(t, 1, len(store.dataKeys))
+	})
+
+	t.Run("TestUpdateVersionWriteFails", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Original")))
+		store.failWriteFragmentVersion = true
+		assert.ErrorIs(t, frag.SetData([]byte("Updated data")), errInjected)
+		assertConsistent(t, frag.OwnerId, frag.Id, []byte("Original"))
+		assert.Equal(t, 1, len(store.dataKeys))
+	})
+
+	t.Run("TestUpdateKeepsPreviousVersionData", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Original")))
+		assert.NoError(t, frag.SetData([]byte("Updated data")))
+		assertConsistent(t, frag.OwnerId, frag.Id, []byte("Updated data"))
+		assert.Equal(t, 2, len(store.dataKeys))
+	})
+
+	t.Run("TestDeleteMetadataDeleteFails", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		store.failDeleteFragment = true
+		assert.Equal(t, false, fragment.DeleteFragment(frag.OwnerId, frag.Id))
+		assertConsistent(t, frag.OwnerId, frag.Id, []byte("Sample data"))
+	})
+
+	t.Run("TestDeleteDataDeleteFails", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		store.failDeleteFragmentData = true
+		assert.Equal(t, true, fragment.DeleteFragment(frag.OwnerId, frag.Id))
+		assertConsistent(t, frag.OwnerId, frag.Id, nil)
+	})
+
+	t.Run("TestDeleteRemovesData", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		assert.NoError(t, frag.SetData([]byte("Updated data")))
+		assert.Equal(t, true, fragment.DeleteFragment(frag.OwnerId, frag.Id))
+		assertConsistent(t, frag.OwnerId, frag.Id, nil)
+		assert.Empty(t, store.dataKeys)
+	})
+}
@@ -0,0 +1,120 @@
+package fragment_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the conversion tests")
+
+func TestConverters(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	writeFragment := func(t *testing.T, fragmentType string, data string) *fragment.Fragment {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		frag.FragmentType = fragmentType
+		assert.NoError(t, frag.SetData([]byte(data)))
+		return &frag
+	}
+
+	// Types only used by these tests so the registrations don't affect other tests
+	fragment.RegisterConverter(fragment.Converter{From: "x-test/exact", To: "x-test/upper", Convert: func(data []byte) ([]byte, error) {
+		return bytes.ToUpper(data), nil
+	}})
+	fragment.RegisterConverter(fragment.Converter{From: "x-test/*", To: "x-test/upper", Convert: func(data []byte) ([]byte, error) {
+		return []byte("wildcard"), nil
+	}})
+
+	t.Run("TestConvertMarkdown", func(t *testing.T) {
+		frag := writeFragment(t, "text/markdown", "### Hello!\n")
+		data, mimeType, err := frag.ConvertMimetype(".html")
+		assert.NoError(t, err)
+		assert.Equal(t, "text/html", mimeType)
+		assert.Equal(t, "<h3>Hello!</h3>\n", string(data))
+
+		data, mimeType, err = frag.ConvertMimetype(".txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "text/plain", mimeType)
+		assert.Equal(t, "Hello!\n", string(data))
+
+		data, mimeType, err = frag.ConvertMimetype(".md")
+		assert.NoError(t, err)
+		assert.Equal(t, "text/markdown", mimeType)
+		assert.Equal(t, "### Hello!\n", string(data))
+	})
+
+	t.Run("TestRegisteredConverters", func(t *testing.T) {
+		frag := writeFragment(t, "x-test/exact; charset=utf-8", "hello")
+		assert.Equal(t, []string{"x-test/exact", "x-test/upper"}, frag.Formats())
+		data, mimeType, err := frag.ConvertTo("x-test/upper")
+		assert.NoError(t, err)
+		assert.Equal(t, "x-test/upper", mimeType)
+		assert.Equal(t, "HELLO", string(data))
+
+		frag = writeFragment(t, "x-test/other", "hello")
+		assert.Equal(t, []string{"x-test/other", "x-test/upper"}, frag.Formats())
+		data, _, _ = frag.ConvertTo("x-test/upper")
+		assert.Equal(t, "wildcard", string(data))
+	})
+
+	// Each input is converted to the type and compared to the input's .golden file
+	t.Run("TestTextConversions", func(t *testing.T) {
+		cases := []struct {
+			input        string
+			fragmentType string
+			mimeType     string
+		}{
+			{"article.html", "text/html; charset=utf-8", "text/plain"},
+			{"notes.md", "text/markdown", "text/plain"},
+			{"plain.txt", "text/plain", "text/html"},
+			{"data.csv", "text/csv", "text/plain"},
+		}
+		for _, tc := range cases {
+			input, err := os.ReadFile(filepath.Join("testdata", "convert", tc.input))
+			assert.NoError(t, err)
+			frag := writeFragment(t, tc.fragmentType, string(input))
+			assert.Contains(t, frag.Formats(), tc.mimeType)
+			data, mimeType, err := frag.ConvertTo(tc.mimeType)
+			assert.NoError(t, err, tc.input)
+			assert.Equal(t, tc.mimeType, mimeType)
+
+			golden := filepath.Join("testdata", "convert", tc.input+".golden")
+			if *update {
+				assert.NoError(t, os.WriteFile(golden, data, 0644))
+			}
+			expected, err := os.ReadFile(golden)
+			assert.NoError(t, err)
+			assert.Equal(t, string(expected), string(data), tc.input)
+		}
+	})
+
+	t.Run("TestUnsupportedConversions", func(t *testing.T) {
+		frag := writeFragment(t, "application/json", "{}")
+		assert.Equal(t, []string{"application/json"}, frag.Formats())
+		_, _, err := frag.ConvertMimetype(".html")
+		assert.ErrorIs(t, err, fragment.ErrUnsupportedConversion)
+		_, _, err = frag.ConvertMimetype(".txt")
+		assert.ErrorIs(t, err, fragment.ErrUnsupportedConversion)
+		_, _, err = frag.ConvertMimetype(".invalidextension")
+		assert.ErrorIs(t, err, fragment.ErrUnsupportedConversion)
+	})
+
+	t.Run("TestFormatsMatchConversions", func(t *testing.T) {
+		for _, fragmentType := range []string{"text/markdown", "text/md", "text/html", "text/plain", "text/csv", "x-test/exact", "application/json"} {
+			frag := writeFragment(t, fragmentType, "data")
+			for _, format := range frag.Formats() {
+				_, _, err := frag.ConvertTo(format)
+				assert.NoError(t, err, fragmentType+" to "+format)
+			}
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package fragment_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func setDedup(t *testing.T, scope string) {
+	previous := config.Dedup
+	config.Dedup = scope
+	t.Cleanup(func() { config.Dedup = previous })
+}
+
+func blobCount(t *testing.T, store *fragment.MemoryStore, ownerId string) int {
+	blobs, err := store.ListFragmentData(ownerId)
+	assert.NoError(t, err)
+	return len(blobs)
+}
+
+// Returns the key the data is deduplicated under when it is stored as is
+func contentKey(data string) string {
+	hash := sha256.Sum256([]byte(data))
+	return "sha256-" + hex.EncodeToString(hash[:])
+}
+
+func TestDedup(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestIdenticalDataIsStoredOncePerOwner", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		setDedup(t, "owner")
+		first := testutils.CreateTestFragment()
+		second := testutils.CreateTestFragment()
+		second.Id = "2"
+		assert.NoError(t, first.SetData([]byte("Boilerplate")))
+		assert.NoError(t, second.SetData([]byte("Boilerplate")))
+
+		assert.Equal(t, first.DataKey, second.DataKey)
+		assert.Equal(t, "sha256-"+first.Hash, first.DataKey)
+		assert.Equal(t, 1, blobCount(t, store, first.OwnerId))
+		refs, _ := store.GetBlobRefs(first.OwnerId, first.DataKey)
+		assert.Equal(t, 2, refs)
+
+		// The blob is only removed with the last reference
+		assert.Equal(t, true, fragment.DeleteFragment(first.OwnerId, first.Id))
+		data, err := second.GetData()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("Boilerplate"), data)
+		assert.Equal(t, true, fragment.DeleteFragment(second.OwnerId, second.Id))
+		assert.Equal(t, 0, blobCount(t, store, first.OwnerId))
+	})
+
+	t.Run("TestGlobalDedup", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		setDedup(t, "global")
+		first := testutils.CreateTestFragment()
+		second := testutils.CreateTestFragment()
+		second.OwnerId = "other"
+		assert.NoError(t, first.SetData([]byte("Boilerplate")))
+		assert.NoError(t, second.SetData([]byte("Boilerplate")))
+
+		assert.Equal(t, 0, blobCount(t, store, first.OwnerId))
+		assert.Equal(t, 0, blobCount(t, store, second.OwnerId))
+		assert.Equal(t, 1, blobCount(t, store, "_shared"))
+		data, _ := second.GetData()
+		assert.Equal(t, []byte("Boilerplate"), data)
+
+		report, err := fragment.Reconcile(fragment.ReconcileOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, report.Discrepancies)
+
+		fragment.DeleteFragment(first.OwnerId, first.Id)
+		fragment.DeleteFragment(second.OwnerId, second.Id)
+		assert.Equal(t, 0, blobCount(t, store, "_shared"))
+	})
+
+	t.Run("TestVersionsHoldReferences", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		setDedup(t, "owner")
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("First")))
+		firstKey := frag.DataKey
+		assert.NoError(t, frag.SetData([]byte("Second")))
+		_, err := fragment.RollbackFragment(frag.OwnerId, frag.Id, 1)
+		assert.NoError(t, err)
+
+		refs, _ := store.GetBlobRefs(frag.OwnerId, firstKey)
+		assert.Equal(t, 2, refs)
+		assert.Equal(t, 2, blobCount(t, store, frag.OwnerId))
+
+		fragment.DeleteFragment(frag.OwnerId, frag.Id)
+		assert.Equal(t, 0, blobCount(t, store, frag.OwnerId))
+		refs, _ = store.GetBlobRefs(frag.OwnerId, firstKey)
+		assert.Equal(t, 0, refs)
+	})
+
+	t.Run("TestReconcileUnreferencedContentBlob", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		testutils.WriteBlob(store, "_shared", "sha256-abc", []byte("Sample data"))
+
+		report, err := fragment.Reconcile(fragment.ReconcileOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(report.Discrepancies))
+		assert.Equal(t, fragment.OrphanData, report.Discrepancies[0].Kind)
+	})
+
+	t.Run("TestCopyFailureReleasesReference", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		setDedup(t, "owner")
+		store.failCopyFragmentData = true
+		frag := testutils.CreateTestFragment()
+		assert.ErrorIs(t, frag.SetData([]byte("Boilerplate")), errInjected)
+		refs, _ := store.GetBlobRefs(frag.OwnerId, contentKey("Boilerplate"))
+		assert.Equal(t, 0, refs)
+		assert.Equal(t, 0, blobCount(t, store.MemoryStore, frag.OwnerId))
+
+		store.failCopyFragmentData = false
+		assert.NoError(t, frag.SetData([]byte("Boilerplate")))
+		data, err := frag.GetData()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("Boilerplate"), data)
+	})
+
+	t.Run("TestSecondWriterCopiesMissingBlob", func(t *testing.T) {
+		store := setupFaultyStore(t)
+		setDedup(t, "owner")
+		// A first writer took its reference but its copy fails
+		key := contentKey("Boilerplate")
+		store.AddBlobRefs("user", key, 1)
+
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Boilerplate")))
+		assert.Equal(t, key, frag.DataKey)
+		store.AddBlobRefs("user", key, -1)
+
+		data, err := frag.GetData()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("Boilerplate"), data)
+		refs, _ := store.GetBlobRefs("user", key)
+		assert.Equal(t, 1, refs)
+	})
+
+	t.Run("TestBlobBeingDeletedIsNotReferenced", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		setDedup(t, "owner")
+		key := contentKey("Boilerplate")
+		claimed, err := store.ClaimBlobDeletion("user", key)
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+		_, err = store.AddBlobRefs("user", key, 1)
+		assert.ErrorIs(t, err, fragment.ErrBlobDeleting)
+
+		// The write keeps its data under its own key
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Boilerplate")))
+		assert.NotEqual(t, key, frag.DataKey)
+		data, _ := frag.GetData()
+		assert.Equal(t, []byte("Boilerplate"), data)
+
+		assert.NoError(t, store.EndBlobDeletion("user", key))
+		refs, err := store.AddBlobRefs("user", key, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, refs)
+		// Referenced blobs can't be claimed
+		claimed, _ = store.ClaimBlobDeletion("user", key)
+		assert.False(t, claimed)
+	})
+}
@@ -0,0 +1,165 @@
+package fragment_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+var oldMasterKey = bytes.Repeat([]byte{1}, 32)
+var newMasterKey = bytes.Repeat([]byte{2}, 32)
+
+func setMasterKeys(t *testing.T, keys map[string][]byte, current string) {
+	provider, err := fragment.NewStaticKeyProvider(keys, current)
+	assert.NoError(t, err)
+	fragment.SetKeyProvider(provider)
+	t.Cleanup(func() { fragment.SetKeyProvider(nil) })
+}
+
+func TestEncryption(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestRoundTrip", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey}, "old")
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		assert.NotEqual(t, "", frag.KeyId)
+		stored, _ := testutils.ReadBlob(store, frag.OwnerId, frag.DataKey)
+		assert.False(t, bytes.Contains(stored, []byte("Sample data")))
+		assert.Equal(t, len(stored), frag.StoredSize)
+		data, err := frag.GetData()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("Sample data"), data)
+
+		// Every write in the namespace shares the data key
+		other := testutils.CreateTestFragment()
+		other.Id = "2"
+		assert.NoError(t, other.SetData([]byte("Other data")))
+		assert.Equal(t, frag.KeyId, other.KeyId)
+	})
+
+	t.Run("TestBlobsUseTheirOwnSalt", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey}, "old")
+		first := testutils.CreateTestFragment()
+		second := testutils.CreateTestFragment()
+		second.Id = "2"
+		assert.NoError(t, first.SetData([]byte("Sample data")))
+		assert.NoError(t, second.SetData([]byte("Sample data")))
+
+		// Version byte, salt, nonce prefix, data and tag
+		firstStored, _ := testutils.ReadBlob(store, first.OwnerId, first.DataKey)
+		secondStored, _ := testutils.ReadBlob(store, second.OwnerId, second.DataKey)
+		assert.Equal(t, 1+32+7+len("Sample data")+16, len(firstStored))
+		assert.Equal(t, byte(2), firstStored[0])
+		assert.NotEqual(t, firstStored[1:33], secondStored[1:33])
+		assert.NotEqual(t, firstStored[40:], secondStored[40:])
+	})
+
+	t.Run("TestLargeData", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey}, "old")
+		setCompression(t, fragment.EncodingGzip)
+		data := make([]byte, 200*1024)
+		rand.Read(data)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData(data))
+
+		retrievedData, err := frag.GetData()
+		assert.NoError(t, err)
+		assert.Equal(t, data, retrievedData)
+		reader, err := frag.OpenDataRange(100*1024, 10)
+		assert.NoError(t, err)
+		part, _ := io.ReadAll(reader)
+		reader.Close()
+		assert.Equal(t, data[100*1024:100*1024+10], part)
+	})
+
+	t.Run("TestTamperedData", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey}, "old")
+		data := make([]byte, 100*1024)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData(data))
+		stored, _ := testutils.ReadBlob(store, frag.OwnerId, frag.DataKey)
+
+		tampered := bytes.Clone(stored)
+		tampered[len(tampered)-1] ^= 1
+		testutils.WriteBlob(store, frag.OwnerId, frag.DataKey, tampered)
+		_, err := frag.GetData()
+		assert.ErrorIs(t, err, fragment.ErrDataAuthentication)
+
+		// Dropping the last chunk is detected as well
+		testutils.WriteBlob(store, frag.OwnerId, frag.DataKey, stored[:8+64*1024+16])
+		_, err = frag.GetData()
+		assert.ErrorIs(t, err, fragment.ErrDataAuthentication)
+	})
+
+	t.Run("TestUnencryptedDataStaysReadable", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		assert.Equal(t, "", frag.KeyId)
+
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey}, "old")
+		data, err := frag.GetData()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("Sample data"), data)
+	})
+
+	t.Run("TestRotateDataKeys", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey}, "old")
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey, "new": newMasterKey}, "new")
+		rotated, err := fragment.RotateDataKeys()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, rotated)
+		rotated, _ = fragment.RotateDataKeys()
+		assert.Equal(t, 0, rotated)
+
+		// The old master key is no longer needed
+		setMasterKeys(t, map[string][]byte{"new": newMasterKey}, "new")
+		data, err := frag.GetData()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("Sample data"), data)
+
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey}, "old")
+		_, err = frag.GetData()
+		assert.ErrorIs(t, err, fragment.ErrUnknownMasterKey)
+	})
+
+	t.Run("TestGlobalDedup", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey}, "old")
+		setDedup(t, "global")
+		first := testutils.CreateTestFragment()
+		second := testutils.CreateTestFragment()
+		second.OwnerId = "other"
+		assert.NoError(t, first.SetData([]byte("Sample data")))
+		assert.NoError(t, second.SetData([]byte("Sample data")))
+
+		// Shared blobs are encrypted with the data key of the shared namespace
+		assert.Equal(t, first.DataKey, second.DataKey)
+		data, err := second.GetData()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("Sample data"), data)
+	})
+
+	t.Run("TestInvalidMasterKey", func(t *testing.T) {
+		_, err := fragment.NewStaticKeyProvider(map[string][]byte{"short": []byte("short")}, "short")
+		assert.Error(t, err)
+		_, err = fragment.NewStaticKeyProvider(map[string][]byte{"old": oldMasterKey}, "missing")
+		assert.ErrorIs(t, err, fragment.ErrUnknownMasterKey)
+	})
+}
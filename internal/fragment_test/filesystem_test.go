@@ -0,0 +1,93 @@
+package fragment_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSystemStore(t *testing.T) {
+	t.Run("TestWriteAndReadFragmentData", func(t *testing.T) {
+		root := t.TempDir()
+		store, err := fragment.NewFileSystemStore(root, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := []byte("Sample data")
+		assert.NoError(t, testutils.WriteBlob(store, "user", "1", data))
+
+		// Uses the same <ownerId>/<fragmentId> layout as S3
+		onDisk, err := os.ReadFile(filepath.Join(root, "user", "1"))
+		assert.NoError(t, err)
+		assert.Equal(t, data, onDisk)
+
+		retrievedData, err := testutils.ReadBlob(store, "user", "1")
+		assert.NoError(t, err)
+		assert.Equal(t, data, retrievedData)
+	})
+
+	t.Run("TestStreamingWriteReturnsSize", func(t *testing.T) {
+		store, _ := fragment.NewFileSystemStore(t.TempDir(), false)
+		data := strings.Repeat("Sample data ", 10000)
+		size, err := store.WriteFragmentData("user", "1", strings.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), size)
+
+		reader, err := store.ReadFragmentData("user", "1")
+		assert.NoError(t, err)
+		defer reader.Close()
+		retrievedData, _ := io.ReadAll(reader)
+		assert.Equal(t, data, string(retrievedData))
+	})
+
+	t.Run("TestReadFragmentDataRange", func(t *testing.T) {
+		store, _ := fragment.NewFileSystemStore(t.TempDir(), false)
+		testutils.WriteBlob(store, "user", "1", []byte("0123456789"))
+		reader, err := store.ReadFragmentDataRange("user", "1", 3, 4)
+		assert.NoError(t, err)
+		defer reader.Close()
+		data, _ := io.ReadAll(reader)
+		assert.Equal(t, []byte("3456"), data)
+	})
+
+	t.Run("TestOverwriteLeavesNoTempFiles", func(t *testing.T) {
+		root := t.TempDir()
+		store, _ := fragment.NewFileSystemStore(root, false)
+		testutils.WriteBlob(store, "user", "1", []byte("First"))
+		testutils.WriteBlob(store, "user", "1", []byte("Second"))
+
+		entries, _ := os.ReadDir(filepath.Join(root, "user"))
+		assert.Equal(t, 1, len(entries))
+		retrievedData, _ := testutils.ReadBlob(store, "user", "1")
+		assert.Equal(t, []byte("Second"), retrievedData)
+	})
+
+	t.Run("TestReadMissingFragmentData", func(t *testing.T) {
+		store, _ := fragment.NewFileSystemStore(t.TempDir(), false)
+		_, err := testutils.ReadBlob(store, "user", "missing")
+		assert.ErrorIs(t, err, fragment.ErrFragmentDataNotFound)
+	})
+
+	t.Run("TestDeleteFragmentData", func(t *testing.T) {
+		store, _ := fragment.NewFileSystemStore(t.TempDir(), true)
+		testutils.WriteBlob(store, "user", "1", []byte("Sample data"))
+		assert.NoError(t, store.DeleteFragmentData("user", "1"))
+		_, err := testutils.ReadBlob(store, "user", "1")
+		assert.ErrorIs(t, err, fragment.ErrFragmentDataNotFound)
+
+		// Deleting data that doesn't exist is not an error, same as S3
+		assert.NoError(t, store.DeleteFragmentData("user", "1"))
+	})
+
+	t.Run("TestRejectsPathTraversal", func(t *testing.T) {
+		store, _ := fragment.NewFileSystemStore(t.TempDir(), false)
+		assert.Error(t, testutils.WriteBlob(store, "..", "1", []byte("data")))
+		assert.Error(t, testutils.WriteBlob(store, "user", "../1", []byte("data")))
+	})
+}
@@ -2,6 +2,8 @@ package fragment_test
 
 import (
 	"encoding/json"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/Jashanpreet2/fragments/internal/fragment"
@@ -22,19 +24,43 @@ func TestFragment(t *testing.T) {
 
 	t.Run("TestSetData", func(t *testing.T) {
 		frag := testutils.CreateTestFragment()
-		ok := frag.SetData([]byte("Sample data"))
-		assert.Equal(t, true, ok)
+		err := frag.SetData([]byte("Sample data"))
+		assert.NoError(t, err)
 	})
 
 	t.Run("TestGetData", func(t *testing.T) {
 		frag := testutils.CreateTestFragment()
 		data := []byte("Sample data")
 		frag.SetData(data)
-		retrievedData, ok := frag.GetData()
-		assert.Equal(t, true, ok)
+		retrievedData, err := frag.GetData()
+		assert.NoError(t, err)
 		assert.Equal(t, retrievedData, data)
 	})
 
+	t.Run("TestWriteAndOpenData", func(t *testing.T) {
+		frag := testutils.CreateTestFragment()
+		data := strings.Repeat("Sample data ", 1000)
+		assert.NoError(t, frag.WriteData(strings.NewReader(data)))
+		assert.Equal(t, len(data), frag.Size)
+
+		reader, err := frag.OpenData()
+		assert.NoError(t, err)
+		defer reader.Close()
+		retrievedData, _ := io.ReadAll(reader)
+		assert.Equal(t, data, string(retrievedData))
+	})
+
+	t.Run("TestETag", func(t *testing.T) {
+		frag := testutils.CreateTestFragment()
+		// Fragments written before hashes were recorded get a weak tag
+		assert.Equal(t, `W/"1"`, frag.ETag())
+
+		frag.SetData([]byte("Sample data"))
+		// sha256 of "Sample data"
+		assert.Equal(t, "d04815cd381cbd2edff87af962484e398563c328d1786f86b78c86fdb98c2de6", frag.Hash)
+		assert.Equal(t, `"`+frag.Hash+`"`, frag.ETag())
+	})
+
 	t.Run("TestGetUserFragmentIds", func(t *testing.T) {
 		frag := testutils.CreateTestFragment()
 		frag.Save()
@@ -49,9 +75,9 @@ func TestFragment(t *testing.T) {
 	t.Run("TestGetFragment", func(t *testing.T) {
 		frag := testutils.CreateTestFragment()
 		frag.Save()
-		retrievedFrag, ok := fragment.GetFragment(frag.OwnerId, frag.Id)
-		assert.Equal(t, true, ok)
-		assert.Equal(t, frag, retrievedFrag)
+		retrievedFrag, err := fragment.GetFragment(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, &frag, retrievedFrag)
 	})
 
 	t.Run("TestDeleteFragment", func(t *testing.T) {
@@ -62,23 +88,25 @@ func TestFragment(t *testing.T) {
 
 	t.Run("TestSave", func(t *testing.T) {
 		frag := testutils.CreateTestFragment()
-		assert.Equal(t, true, frag.Save())
+		assert.NoError(t, frag.Save())
 	})
 
 	t.Run("TestFormatsForMd", func(t *testing.T) {
 		frag := testutils.CreateTestFragment()
 		frag.FragmentType = "text/md"
 		formats := frag.Formats()
-		assert.Equal(t, 3, len(formats))
+		assert.Equal(t, 4, len(formats))
 		assert.Contains(t, formats, "text/html")
+		assert.Contains(t, formats, "text/plain")
 		assert.Contains(t, formats, "text/md")
 		assert.Contains(t, formats, "text/markdown")
 	})
 
 	t.Run("TestGetDataWithoutSavingData", func(t *testing.T) {
 		frag := testutils.CreateTestFragment()
-		data, ok := frag.GetData()
+		fragment.ResetDB()
+		data, err := frag.GetData()
 		assert.Equal(t, []byte(nil), data)
-		assert.Equal(t, false, ok)
+		assert.Error(t, err)
 	})
 }
@@ -0,0 +1,108 @@
+package fragment_test
+
+import (
+	"bytes"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedIDGenerator struct{ id string }
+
+func (generator fixedIDGenerator) NewID() string {
+	return generator.id
+}
+
+func assertSortedIds(t *testing.T, generator fragment.IDGenerator, pattern string) {
+	ids := []string{}
+	for range 3 {
+		ids = append(ids, generator.NewID())
+		time.Sleep(2 * time.Millisecond)
+	}
+	for _, id := range ids {
+		assert.Regexp(t, regexp.MustCompile(pattern), id)
+	}
+	assert.True(t, slices.IsSorted(ids), ids)
+	assert.NotEqual(t, generator.NewID(), generator.NewID())
+}
+
+func TestIDGenerators(t *testing.T) {
+	t.Run("TestUUIDv7", func(t *testing.T) {
+		assertSortedIds(t, fragment.UUIDv7Generator{}, `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	})
+
+	t.Run("TestULID", func(t *testing.T) {
+		assertSortedIds(t, fragment.ULIDGenerator{}, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	})
+}
+
+func TestCreateFragment(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestDefaultGenerator", func(t *testing.T) {
+		assert.Regexp(t, `^[0-9a-f-]{36}$`, fragment.GenerateID())
+		fragment.SetIDGenerator(fixedIDGenerator{"fixed"})
+		t.Cleanup(func() { fragment.SetIDGenerator(nil) })
+		assert.Equal(t, "fixed", fragment.GenerateID())
+	})
+
+	t.Run("TestCreateDoesNotOverwrite", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.CreateData(bytes.NewReader([]byte("Sample data"))))
+
+		collision := testutils.CreateTestFragment()
+		err := collision.CreateData(bytes.NewReader([]byte("Other data")))
+		assert.ErrorIs(t, err, fragment.ErrFragmentExists)
+		stored, _ := fragment.GetFragment("user", "1")
+		data, _ := stored.GetData()
+		assert.Equal(t, "Sample data", string(data))
+		versions, _ := fragment.GetFragmentVersions("user", "1")
+		assert.Equal(t, 1, len(versions))
+	})
+
+	t.Run("TestWriteAfterConcurrentCreate", func(t *testing.T) {
+		store := setupInterleavingStore(t)
+		store.interleave = func() {
+			other := testutils.CreateTestFragment()
+			assert.NoError(t, other.SetData([]byte("Other data")))
+		}
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		assert.Equal(t, 2, frag.Version)
+		stored, _ := fragment.GetFragment("user", "1")
+		data, _ := stored.GetData()
+		assert.Equal(t, "Sample data", string(data))
+		versions, _ := fragment.GetFragmentVersions("user", "1")
+		assert.Equal(t, 2, len(versions))
+	})
+
+	t.Run("TestCreateAfterConcurrentCreate", func(t *testing.T) {
+		store := setupInterleavingStore(t)
+		store.interleave = func() {
+			other := testutils.CreateTestFragment()
+			assert.NoError(t, other.CreateData(bytes.NewReader([]byte("Other data"))))
+		}
+		frag := testutils.CreateTestFragment()
+		err := frag.CreateData(bytes.NewReader([]byte("Sample data")))
+		assert.ErrorIs(t, err, fragment.ErrFragmentExists)
+		stored, _ := fragment.GetFragment("user", "1")
+		data, _ := stored.GetData()
+		assert.Equal(t, "Other data", string(data))
+	})
+
+	t.Run("TestStoreCreateFragment", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, store.CreateFragment(&frag))
+		assert.ErrorIs(t, store.CreateFragment(&frag), fragment.ErrFragmentExists)
+		frag.OwnerId = "other"
+		assert.NoError(t, store.CreateFragment(&frag))
+	})
+}
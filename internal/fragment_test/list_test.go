@@ -0,0 +1,121 @@
+package fragment_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func listedIds(page *fragment.FragmentPage) []string {
+	ids := []string{}
+	for _, frag := range page.Fragments {
+		ids = append(ids, frag.Id)
+	}
+	return ids
+}
+
+func TestListFragments(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	// Every store filters, sorts and paginates listings itself
+	stores := map[string]func(t *testing.T) fragment.FragmentStore{
+		"Memory": func(t *testing.T) fragment.FragmentStore {
+			return testutils.SetupMemoryStore(t)
+		},
+		"SQLite": func(t *testing.T) fragment.FragmentStore {
+			store := newTestSQLiteStore(t)
+			fragment.SetStores(store, fragment.NewMemoryStore())
+			t.Cleanup(fragment.ResetDB)
+			return store
+		},
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, newStore := range stores {
+		setupListing := func(t *testing.T) fragment.FragmentStore {
+			store := newStore(t)
+			for i, size := range []int{30, 10, 20, 10} {
+				frag := testutils.CreateTestFragment()
+				frag.Id = string(rune('a' + i))
+				frag.Size = size
+				frag.Created = start.Add(time.Duration(i) * time.Hour)
+				frag.Updated = start.Add(time.Duration(10-i) * time.Hour)
+				if i == 3 {
+					frag.FragmentType = "text/markdown; charset=utf-8"
+				}
+				if i%2 == 0 {
+					frag.Tags = []string{"work"}
+				}
+				store.WriteFragment(&frag)
+			}
+			trashed := testutils.CreateTestFragment()
+			trashed.Id = "trashed"
+			trashed.Deleted = &start
+			store.WriteFragment(&trashed)
+			return store
+		}
+
+		t.Run("Test"+name, func(t *testing.T) {
+			t.Run("TestDefaultOrder", func(t *testing.T) {
+				setupListing(t)
+				page, err := fragment.ListFragments("user", fragment.ListOptions{})
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"a", "b", "c", "d"}, listedIds(page))
+				assert.Equal(t, "", page.NextCursor)
+			})
+
+			t.Run("TestSort", func(t *testing.T) {
+				setupListing(t)
+				page, _ := fragment.ListFragments("user", fragment.ListOptions{Sort: fragment.SortSize})
+				// Ties are broken by id
+				assert.Equal(t, []string{"b", "d", "c", "a"}, listedIds(page))
+				page, _ = fragment.ListFragments("user", fragment.ListOptions{Sort: fragment.SortSize, Descending: true})
+				assert.Equal(t, []string{"a", "c", "d", "b"}, listedIds(page))
+				page, _ = fragment.ListFragments("user", fragment.ListOptions{Sort: fragment.SortUpdated})
+				assert.Equal(t, []string{"d", "c", "b", "a"}, listedIds(page))
+				_, err := fragment.ListFragments("user", fragment.ListOptions{Sort: "name"})
+				assert.Error(t, err)
+			})
+
+			t.Run("TestFilters", func(t *testing.T) {
+				setupListing(t)
+				page, _ := fragment.ListFragments("user", fragment.ListOptions{Type: "text/markdown"})
+				assert.Equal(t, []string{"d"}, listedIds(page))
+				page, _ = fragment.ListFragments("user", fragment.ListOptions{CreatedAfter: start, CreatedBefore: start.Add(3 * time.Hour)})
+				assert.Equal(t, []string{"b", "c"}, listedIds(page))
+				page, _ = fragment.ListFragments("user", fragment.ListOptions{UpdatedAfter: start.Add(8 * time.Hour)})
+				assert.Equal(t, []string{"a", "b"}, listedIds(page))
+				page, _ = fragment.ListFragments("user", fragment.ListOptions{MinSize: 15, MaxSize: 25})
+				assert.Equal(t, []string{"c"}, listedIds(page))
+				page, _ = fragment.ListFragments("user", fragment.ListOptions{Tags: []string{"work"}, Sort: fragment.SortSize})
+				assert.Equal(t, []string{"c", "a"}, listedIds(page))
+			})
+
+			t.Run("TestPagination", func(t *testing.T) {
+				store := setupListing(t)
+				options := fragment.ListOptions{Sort: fragment.SortSize, Limit: 3}
+				page, err := fragment.ListFragments("user", options)
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"b", "d", "c"}, listedIds(page))
+
+				// Fragments removed before the cursor don't shift the next page
+				store.DeleteFragment("user", "b")
+				options.Cursor = page.NextCursor
+				page, err = fragment.ListFragments("user", options)
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"a"}, listedIds(page))
+				assert.Equal(t, "", page.NextCursor)
+
+				// Cursors only work with the listing they came from
+				options.Sort = fragment.SortCreated
+				_, err = fragment.ListFragments("user", options)
+				assert.ErrorIs(t, err, fragment.ErrInvalidCursor)
+				_, err = fragment.ListFragments("user", fragment.ListOptions{Cursor: "not a cursor"})
+				assert.ErrorIs(t, err, fragment.ErrInvalidCursor)
+			})
+		})
+	}
+}
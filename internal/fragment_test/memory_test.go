@@ -0,0 +1,74 @@
+package fragment_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	t.Run("TestWriteAndGetFragment", func(t *testing.T) {
+		store := fragment.NewMemoryStore()
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, store.WriteFragment(&frag))
+		retrievedFrag, err := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, &frag, retrievedFrag)
+	})
+
+	t.Run("TestGetMissingFragment", func(t *testing.T) {
+		store := fragment.NewMemoryStore()
+		retrievedFrag, err := store.GetFragment("user", "missing")
+		assert.NoError(t, err)
+		assert.Nil(t, retrievedFrag)
+	})
+
+	t.Run("TestGetFragmentIds", func(t *testing.T) {
+		store := fragment.NewMemoryStore()
+		frag := testutils.CreateTestFragment()
+		store.WriteFragment(&frag)
+		ids, err := store.GetFragmentIds(frag.OwnerId)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{frag.Id}, ids)
+	})
+
+	t.Run("TestDeleteFragment", func(t *testing.T) {
+		store := fragment.NewMemoryStore()
+		frag := testutils.CreateTestFragment()
+		store.WriteFragment(&frag)
+		assert.NoError(t, store.DeleteFragment(frag.OwnerId, frag.Id))
+		retrievedFrag, _ := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.Nil(t, retrievedFrag)
+	})
+
+	t.Run("TestFragmentData", func(t *testing.T) {
+		store := fragment.NewMemoryStore()
+		data := []byte("Sample data")
+		assert.NoError(t, testutils.WriteBlob(store, "user", "1", data))
+		data[0] = 'X'
+		retrievedData, err := testutils.ReadBlob(store, "user", "1")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("Sample data"), retrievedData)
+
+		assert.NoError(t, store.DeleteFragmentData("user", "1"))
+		_, err = testutils.ReadBlob(store, "user", "1")
+		assert.ErrorIs(t, err, fragment.ErrFragmentDataNotFound)
+	})
+
+	t.Run("TestFragmentDataRange", func(t *testing.T) {
+		store := fragment.NewMemoryStore()
+		testutils.WriteBlob(store, "user", "1", []byte("0123456789"))
+		reader, err := store.ReadFragmentDataRange("user", "1", 3, 4)
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(reader)
+		assert.Equal(t, []byte("3456"), data)
+
+		_, err = store.ReadFragmentDataRange("user", "1", 8, 4)
+		assert.Error(t, err)
+		_, err = store.ReadFragmentDataRange("user", "missing", 0, 1)
+		assert.ErrorIs(t, err, fragment.ErrFragmentDataNotFound)
+	})
+}
@@ -0,0 +1,154 @@
+package fragment_test
+
+import (
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := fragment.NormalizeTags([]string{" Draft", "work", "draft "})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"draft", "work"}, tags)
+
+	tags, err = fragment.NormalizeTags(nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, tags)
+
+	for _, invalid := range [][]string{{""}, {"a,b"}, {string(make([]byte, 65))}, make([]string, 33)} {
+		_, err := fragment.NormalizeTags(invalid)
+		assert.ErrorIs(t, err, fragment.ErrInvalidMetadata)
+	}
+}
+
+func TestNormalizeMetadata(t *testing.T) {
+	metadata, err := fragment.NormalizeMetadata(map[string]string{"Project": "fragments"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"project": "fragments"}, metadata)
+
+	for _, key := range []string{"", "has space", "ünïcode"} {
+		_, err := fragment.NormalizeMetadata(map[string]string{key: "value"})
+		assert.ErrorIs(t, err, fragment.ErrInvalidMetadata)
+	}
+	_, err = fragment.NormalizeMetadata(map[string]string{"key": string(make([]byte, 1025))})
+	assert.ErrorIs(t, err, fragment.ErrInvalidMetadata)
+}
+
+func TestUpdateFragmentMetadata(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	value := func(s string) *string { return &s }
+
+	t.Run("TestUpdateMergesMetadata", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		frag.Tags = []string{"draft"}
+		frag.Metadata = map[string]string{"project": "fragments", "owner": "me"}
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		updated, err := fragment.UpdateFragmentMetadata("user", "1", fragment.MetadataUpdate{
+			Metadata: map[string]*string{"owner": nil, "Status": value("done")},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"draft"}, updated.Tags)
+		assert.Equal(t, map[string]string{"project": "fragments", "status": "done"}, updated.Metadata)
+		assert.Equal(t, 1, updated.Version)
+
+		stored, _ := fragment.GetFragment("user", "1")
+		assert.Equal(t, updated.Metadata, stored.Metadata)
+		// The version keeps the metadata it was written with
+		version, _ := fragment.GetFragmentVersion("user", "1", 1)
+		assert.Equal(t, frag.Metadata, version.Metadata)
+	})
+
+	t.Run("TestUpdateReplacesTags", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		frag.Tags = []string{"draft"}
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		updated, err := fragment.UpdateFragmentMetadata("user", "1", fragment.MetadataUpdate{Tags: &[]string{"Final", "work"}})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"final", "work"}, updated.Tags)
+
+		_, err = fragment.UpdateFragmentMetadata("user", "1", fragment.MetadataUpdate{Tags: &[]string{""}})
+		assert.ErrorIs(t, err, fragment.ErrInvalidMetadata)
+		missing, err := fragment.UpdateFragmentMetadata("user", "2", fragment.MetadataUpdate{Tags: &[]string{"a"}})
+		assert.NoError(t, err)
+		assert.Nil(t, missing)
+	})
+
+	t.Run("TestWritesKeepTagsAndMetadata", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		frag.Tags = []string{"draft"}
+		frag.Metadata = map[string]string{"project": "fragments"}
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		update := testutils.CreateTestFragment()
+		assert.NoError(t, update.SetData([]byte("New data")))
+		assert.Equal(t, []string{"draft"}, update.Tags)
+		assert.Equal(t, frag.Metadata, update.Metadata)
+
+		update = testutils.CreateTestFragment()
+		update.Tags = []string{}
+		assert.NoError(t, update.SetData([]byte("Newer data")))
+		stored, _ := fragment.GetFragment("user", "1")
+		assert.Empty(t, stored.Tags)
+		assert.Equal(t, frag.Metadata, stored.Metadata)
+	})
+
+	t.Run("TestConcurrentUpdatesAreNotLost", func(t *testing.T) {
+		store := setupInterleavingStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		store.interleave = func() {
+			_, err := fragment.UpdateFragmentMetadata("user", "1", fragment.MetadataUpdate{Tags: &[]string{"draft"}})
+			assert.NoError(t, err)
+		}
+		updated, err := fragment.UpdateFragmentMetadata("user", "1", fragment.MetadataUpdate{Metadata: map[string]*string{"status": value("done")}})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"draft"}, updated.Tags)
+		assert.Equal(t, map[string]string{"status": "done"}, updated.Metadata)
+		assert.Equal(t, 2, updated.MetadataRevision)
+	})
+
+	t.Run("TestPreconditionIsCheckedByTheWrite", func(t *testing.T) {
+		store := setupInterleavingStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		read := frag.InfoETag()
+		unchanged := func(current *fragment.Fragment) bool { return current != nil && current.InfoETag() == read }
+
+		// Another update lands between the check and the write
+		store.interleave = func() {
+			_, err := fragment.UpdateFragmentMetadata("user", "1", fragment.MetadataUpdate{Tags: &[]string{"draft"}})
+			assert.NoError(t, err)
+		}
+		_, err := fragment.UpdateFragmentMetadataIf("user", "1", fragment.MetadataUpdate{Tags: &[]string{"final"}}, unchanged)
+		assert.ErrorIs(t, err, fragment.ErrPreconditionFailed)
+		stored, _ := fragment.GetFragment("user", "1")
+		assert.Equal(t, []string{"draft"}, stored.Tags)
+
+		_, err = fragment.UpdateFragmentMetadataIf("user", "2", fragment.MetadataUpdate{Tags: &[]string{"final"}}, unchanged)
+		assert.ErrorIs(t, err, fragment.ErrPreconditionFailed)
+	})
+
+	t.Run("TestListByTags", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		for i, tags := range [][]string{{"draft", "work"}, {"work"}, nil} {
+			frag := testutils.CreateTestFragment()
+			frag.Id = string(rune('a' + i))
+			frag.Tags = tags
+			assert.NoError(t, frag.SetData([]byte("Sample data")))
+		}
+		page, _ := fragment.ListFragments("user", fragment.ListOptions{Tags: []string{"work"}})
+		assert.Equal(t, []string{"a", "b"}, listedIds(page))
+		page, _ = fragment.ListFragments("user", fragment.ListOptions{Tags: []string{"work", "draft"}})
+		assert.Equal(t, []string{"a"}, listedIds(page))
+	})
+}
@@ -0,0 +1,97 @@
+package fragment_test
+
+import (
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func setLimits(t *testing.T, maxFragmentSize int64, quotaBytes int64, quotaFragments int64) {
+	previous := fragment.GetLimits()
+	config.MaxFragmentSize, config.QuotaBytes, config.QuotaFragments = maxFragmentSize, quotaBytes, quotaFragments
+	t.Cleanup(func() {
+		config.MaxFragmentSize, config.QuotaBytes, config.QuotaFragments = previous.MaxFragmentSize, previous.MaxBytes, previous.MaxFragments
+	})
+}
+
+// Counts the fragments read one at a time
+type countingStore struct {
+	*fragment.MemoryStore
+	reads int
+}
+
+func (store *countingStore) GetFragment(ownerId string, id string) (*fragment.Fragment, error) {
+	store.reads++
+	return store.MemoryStore.GetFragment(ownerId, id)
+}
+
+func TestQuota(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestUsage", func(t *testing.T) {
+		fragment.ResetDB()
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		trashed := testutils.CreateTestFragment()
+		trashed.Id = "2"
+		assert.NoError(t, trashed.SetData([]byte("Trash")))
+		fragment.TrashFragment(trashed.OwnerId, trashed.Id)
+
+		usage, err := fragment.GetUsage(frag.OwnerId)
+		assert.NoError(t, err)
+		assert.Equal(t, fragment.Usage{Fragments: 1, Bytes: 11, TrashedFragments: 1, TrashedBytes: 5}, *usage)
+	})
+
+	t.Run("TestUsageListsFragments", func(t *testing.T) {
+		store := &countingStore{MemoryStore: fragment.NewMemoryStore()}
+		fragment.SetStores(store, store.MemoryStore)
+		t.Cleanup(fragment.ResetDB)
+		for _, id := range []string{"1", "2", "3"} {
+			frag := testutils.CreateTestFragment()
+			frag.Id = id
+			assert.NoError(t, frag.SetData([]byte("Sample")))
+		}
+
+		store.reads = 0
+		usage, err := fragment.GetUsage("user")
+		assert.NoError(t, err)
+		assert.Equal(t, fragment.Usage{Fragments: 3, Bytes: 18}, *usage)
+		assert.Equal(t, 0, store.reads)
+	})
+
+	t.Run("TestMaxFragmentSize", func(t *testing.T) {
+		fragment.ResetDB()
+		setLimits(t, 5, 0, 0)
+		assert.NoError(t, fragment.CheckQuota("user", "1", 5))
+		assert.ErrorIs(t, fragment.CheckQuota("user", "1", 6), fragment.ErrFragmentTooLarge)
+	})
+
+	t.Run("TestQuotaBytes", func(t *testing.T) {
+		fragment.ResetDB()
+		setLimits(t, 0, 10, 0)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample")))
+
+		assert.NoError(t, fragment.CheckQuota(frag.OwnerId, "2", 4))
+		assert.ErrorIs(t, fragment.CheckQuota(frag.OwnerId, "2", 5), fragment.ErrQuotaExceeded)
+		// Updates only count the difference to the current size
+		assert.NoError(t, fragment.CheckQuota(frag.OwnerId, frag.Id, 10))
+	})
+
+	t.Run("TestQuotaFragments", func(t *testing.T) {
+		fragment.ResetDB()
+		setLimits(t, 0, 0, 1)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample")))
+
+		assert.ErrorIs(t, fragment.CheckQuota(frag.OwnerId, "2", 1), fragment.ErrQuotaExceeded)
+		assert.NoError(t, fragment.CheckQuota(frag.OwnerId, frag.Id, 1))
+		// Fragments in the trash still count
+		fragment.TrashFragment(frag.OwnerId, frag.Id)
+		assert.ErrorIs(t, fragment.CheckQuota(frag.OwnerId, "2", 1), fragment.ErrQuotaExceeded)
+	})
+}
@@ -0,0 +1,91 @@
+package fragment_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestConsistentStores", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		report, err := fragment.Reconcile(fragment.ReconcileOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.OwnersChecked)
+		assert.Equal(t, 1, report.FragmentsChecked)
+		assert.Equal(t, 1, report.BlobsChecked)
+		assert.Empty(t, report.Discrepancies)
+	})
+
+	t.Run("TestOrphanData", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		testutils.WriteBlob(store, "user", "orphan", []byte("Sample data"))
+
+		// Young blobs may belong to an uncommitted write
+		report, _ := fragment.Reconcile(fragment.ReconcileOptions{MinOrphanAge: time.Hour})
+		assert.Empty(t, report.Discrepancies)
+
+		report, _ = fragment.Reconcile(fragment.ReconcileOptions{DryRun: true})
+		assert.Equal(t, 1, len(report.Discrepancies))
+		assert.Equal(t, fragment.OrphanData, report.Discrepancies[0].Kind)
+		assert.Equal(t, false, report.Discrepancies[0].Repaired)
+		_, err := testutils.ReadBlob(store, "user", "orphan")
+		assert.NoError(t, err)
+
+		report, _ = fragment.Reconcile(fragment.ReconcileOptions{})
+		assert.Equal(t, true, report.Discrepancies[0].Repaired)
+		_, err = testutils.ReadBlob(store, "user", "orphan")
+		assert.ErrorIs(t, err, fragment.ErrFragmentDataNotFound)
+	})
+
+	t.Run("TestMissingData", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		store.WriteFragment(&frag)
+
+		report, _ := fragment.Reconcile(fragment.ReconcileOptions{})
+		assert.Equal(t, 1, len(report.Discrepancies))
+		assert.Equal(t, fragment.MissingData, report.Discrepancies[0].Kind)
+		assert.Equal(t, true, report.Discrepancies[0].Repaired)
+		retrievedFrag, _ := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.Equal(t, true, retrievedFrag.Broken)
+
+		// Broken fragments are not reported again
+		report, _ = fragment.Reconcile(fragment.ReconcileOptions{})
+		assert.Empty(t, report.Discrepancies)
+	})
+
+	t.Run("TestSizeMismatch", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		frag.Size = 1
+		store.WriteFragment(&frag)
+
+		report, _ := fragment.Reconcile(fragment.ReconcileOptions{})
+		assert.Equal(t, 1, len(report.Discrepancies))
+		assert.Equal(t, fragment.SizeMismatch, report.Discrepancies[0].Kind)
+		assert.Equal(t, int64(len("Sample data")), report.Discrepancies[0].DataSize)
+		retrievedFrag, _ := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.Equal(t, len("Sample data"), retrievedFrag.Size)
+	})
+
+	t.Run("TestOwnerFilter", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		testutils.WriteBlob(store, "user", "orphan", []byte("Sample data"))
+		testutils.WriteBlob(store, "other", "orphan", []byte("Sample data"))
+
+		report, _ := fragment.Reconcile(fragment.ReconcileOptions{DryRun: true, OwnerIds: []string{"other"}})
+		assert.Equal(t, 1, report.OwnersChecked)
+		assert.Equal(t, "other", report.Discrepancies[0].OwnerId)
+	})
+}
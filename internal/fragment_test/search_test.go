@@ -0,0 +1,157 @@
+package fragment_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func searchIds(t *testing.T, ownerId string, query string) []string {
+	results, err := fragment.SearchFragments(ownerId, query, 10)
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, result := range results {
+		ids = append(ids, result.Id)
+	}
+	return ids
+}
+
+func writeTextFragment(t *testing.T, ownerId string, id string, text string) *fragment.Fragment {
+	frag := testutils.CreateTestFragment()
+	frag.OwnerId = ownerId
+	frag.Id = id
+	frag.FragmentType = "text/plain"
+	assert.NoError(t, frag.SetData([]byte(text)))
+	return &frag
+}
+
+func TestSearch(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestSearchRanksMatches", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		writeTextFragment(t, "user", "a", "The quick brown fox jumps over the lazy dog")
+		writeTextFragment(t, "user", "b", "Fox after fox after fox")
+		writeTextFragment(t, "user", "c", "Nothing to see here")
+		writeTextFragment(t, "other", "d", "Another fox")
+
+		assert.Equal(t, []string{"b", "a"}, searchIds(t, "user", "fox"))
+		assert.Equal(t, []string{"a"}, searchIds(t, "user", "LAZY fox"))
+		assert.Equal(t, []string{}, searchIds(t, "user", "cat"))
+
+		results, _ := fragment.SearchFragments("user", "lazy", 10)
+		assert.Contains(t, results[0].Snippet, "lazy")
+		assert.Greater(t, results[0].Score, 0.0)
+	})
+
+	t.Run("TestSearchMetadata", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		frag.FragmentType = "text/markdown"
+		assert.NoError(t, frag.SetData([]byte("# Title")))
+		assert.Equal(t, []string{frag.Id}, searchIds(t, "user", "markdown"))
+	})
+
+	t.Run("TestSearchTagsAndCustomMetadata", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		frag.FragmentType = "text/plain"
+		frag.Tags = []string{"groceries"}
+		frag.Metadata = map[string]string{"project": "apollo"}
+		assert.NoError(t, frag.SetData([]byte("Milk and eggs")))
+		assert.Equal(t, []string{frag.Id}, searchIds(t, "user", "groceries"))
+		assert.Equal(t, []string{frag.Id}, searchIds(t, "user", "apollo"))
+
+		tags := []string{"errands"}
+		_, err := fragment.UpdateFragmentMetadata("user", frag.Id, fragment.MetadataUpdate{Tags: &tags})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{}, searchIds(t, "user", "groceries"))
+		assert.Equal(t, []string{frag.Id}, searchIds(t, "user", "errands milk"))
+	})
+
+	t.Run("TestEncryptedContentIsNotIndexed", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		setMasterKeys(t, map[string][]byte{"old": oldMasterKey}, "old")
+		frag := testutils.CreateTestFragment()
+		frag.FragmentType = "text/plain"
+		frag.Tags = []string{"secret"}
+		assert.NoError(t, frag.SetData([]byte("Launch codes")))
+		assert.Equal(t, []string{}, searchIds(t, "user", "launch"))
+		assert.Equal(t, []string{frag.Id}, searchIds(t, "user", "secret"))
+
+		previous := config.SearchEncrypted
+		config.SearchEncrypted = true
+		t.Cleanup(func() { config.SearchEncrypted = previous })
+		assert.NoError(t, frag.SetData([]byte("Launch codes")))
+		assert.Equal(t, []string{frag.Id}, searchIds(t, "user", "launch"))
+	})
+
+	t.Run("TestOutdatedIndexIsRecreated", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "search.db")
+		db, err := sql.Open("sqlite", path)
+		assert.NoError(t, err)
+		_, err = db.Exec(`CREATE VIRTUAL TABLE search_index USING fts5(fragment_type, content)`)
+		assert.NoError(t, err)
+		db.Close()
+
+		index, err := fragment.NewSearchIndex(path)
+		assert.NoError(t, err)
+		defer index.Close()
+		frag := testutils.CreateTestFragment()
+		frag.Tags = []string{"tagged"}
+		assert.NoError(t, index.IndexFragment(&frag, "content"))
+		results, err := index.Search(frag.OwnerId, "tagged", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(results))
+	})
+
+	t.Run("TestQuerySyntaxIsIgnored", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		writeTextFragment(t, "user", "a", "Sample data")
+		for _, query := range []string{`"sample`, `sample*`, `sample OR`, `-data`, `NEAR(sample)`, `***`} {
+			_, err := fragment.SearchFragments("user", query, 10)
+			assert.NoError(t, err, query)
+		}
+	})
+
+	t.Run("TestIndexFollowsChanges", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := writeTextFragment(t, "user", "a", "First draft")
+		assert.NoError(t, frag.SetData([]byte("Second draft")))
+		assert.Equal(t, []string{}, searchIds(t, "user", "first"))
+		assert.Equal(t, []string{"a"}, searchIds(t, "user", "second"))
+
+		_, err := fragment.RollbackFragment("user", "a", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a"}, searchIds(t, "user", "first"))
+
+		fragment.TrashFragment("user", "a")
+		assert.Equal(t, []string{}, searchIds(t, "user", "draft"))
+		fragment.RestoreFragment("user", "a")
+		assert.Equal(t, []string{"a"}, searchIds(t, "user", "draft"))
+		fragment.DeleteFragment("user", "a")
+		assert.Equal(t, []string{}, searchIds(t, "user", "draft"))
+	})
+
+	t.Run("TestRebuildSearchIndex", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		writeTextFragment(t, "user", "a", "Sample data")
+		writeTextFragment(t, "other", "b", "Sample data")
+		index, err := fragment.NewSearchIndex(":memory:")
+		assert.NoError(t, err)
+		fragment.SetSearchIndex(index)
+		assert.Equal(t, []string{}, searchIds(t, "user", "sample"))
+
+		indexed, err := fragment.RebuildSearchIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, indexed)
+		assert.Equal(t, []string{"a"}, searchIds(t, "user", "sample"))
+		assert.Equal(t, []string{"b"}, searchIds(t, "other", "sample"))
+	})
+}
@@ -0,0 +1,299 @@
+package fragment_test
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSQLiteStore(t *testing.T) *fragment.SQLiteStore {
+	store, err := fragment.NewSQLiteStore(filepath.Join(t.TempDir(), "fragments.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteStore(t *testing.T) {
+	t.Run("TestWriteAndGetFragment", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, store.WriteFragment(&frag))
+
+		retrievedFrag, err := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, frag.Id, retrievedFrag.Id)
+		assert.Equal(t, frag.OwnerId, retrievedFrag.OwnerId)
+		assert.Equal(t, frag.FragmentType, retrievedFrag.FragmentType)
+		assert.Equal(t, frag.Size, retrievedFrag.Size)
+		assert.True(t, frag.Created.Equal(retrievedFrag.Created))
+		assert.True(t, frag.Updated.Equal(retrievedFrag.Updated))
+	})
+
+	t.Run("TestEncodingIsStored", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		frag := testutils.CreateTestFragment()
+		frag.Encoding = fragment.EncodingZstd
+		frag.StoredSize = 7
+		assert.NoError(t, store.WriteFragment(&frag))
+
+		retrievedFrag, err := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, fragment.EncodingZstd, retrievedFrag.Encoding)
+		assert.Equal(t, 7, retrievedFrag.StoredSize)
+	})
+
+	t.Run("TestTagsAndMetadataAreStored", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		frag := testutils.CreateTestFragment()
+		frag.Tags = []string{"draft", "work"}
+		frag.Metadata = map[string]string{"project": "fragments"}
+		assert.NoError(t, store.WriteFragment(&frag))
+		assert.NoError(t, store.WriteFragmentVersion(&frag))
+
+		retrievedFrag, _ := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.Equal(t, frag.Tags, retrievedFrag.Tags)
+		assert.Equal(t, frag.Metadata, retrievedFrag.Metadata)
+		version, _ := store.GetFragmentVersion(frag.OwnerId, frag.Id, frag.Version)
+		assert.Equal(t, frag.Tags, version.Tags)
+		listed, _ := store.ListFragments(frag.OwnerId, fragment.ListOptions{})
+		assert.Equal(t, frag.Tags, listed.Fragments[0].Tags)
+	})
+
+	t.Run("TestNameEntries", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		entry := fragment.NameEntry{OwnerId: "user", ParentId: "", Name: "work", Kind: fragment.EntryCollection, Id: "1", Created: time.Now()}
+		assert.NoError(t, store.CreateNameEntry(&entry))
+		assert.ErrorIs(t, store.CreateNameEntry(&entry), fragment.ErrNameTaken)
+		other := entry
+		other.OwnerId = "other"
+		assert.NoError(t, store.CreateNameEntry(&other))
+
+		retrieved, err := store.GetNameEntry("user", "", "work")
+		assert.NoError(t, err)
+		assert.Equal(t, entry.Id, retrieved.Id)
+		assert.True(t, entry.Created.Equal(retrieved.Created))
+		entries, _ := store.ListNameEntries("user")
+		assert.Equal(t, 1, len(entries))
+
+		assert.NoError(t, store.DeleteNameEntry("user", "", "work"))
+		retrieved, err = store.GetNameEntry("user", "", "work")
+		assert.NoError(t, err)
+		assert.Nil(t, retrieved)
+	})
+
+	t.Run("TestCreateFragment", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, store.CreateFragment(&frag))
+		frag.Size = 10
+		assert.ErrorIs(t, store.CreateFragment(&frag), fragment.ErrFragmentExists)
+		retrievedFrag, _ := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.Equal(t, 5, retrievedFrag.Size)
+	})
+
+	t.Run("TestWriteFragmentOverwrites", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		frag := testutils.CreateTestFragment()
+		store.WriteFragment(&frag)
+		frag.Size = 10
+		frag.Updated = frag.Updated.Add(time.Minute)
+		assert.NoError(t, store.WriteFragment(&frag))
+
+		retrievedFrag, _ := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.Equal(t, 10, retrievedFrag.Size)
+		assert.True(t, frag.Updated.Equal(retrievedFrag.Updated))
+	})
+
+	t.Run("TestGetMissingFragment", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		retrievedFrag, err := store.GetFragment("user", "missing")
+		assert.NoError(t, err)
+		assert.Nil(t, retrievedFrag)
+	})
+
+	t.Run("TestGetFragmentIds", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		for _, id := range []string{"b", "a", "c"} {
+			frag := testutils.CreateTestFragment()
+			frag.Id = id
+			store.WriteFragment(&frag)
+		}
+		other := testutils.CreateTestFragment()
+		other.OwnerId = "other"
+		store.WriteFragment(&other)
+
+		ids, err := store.GetFragmentIds("user")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b", "c"}, ids)
+
+		ids, err = store.GetFragmentIds("nobody")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{}, ids)
+	})
+
+	t.Run("TestBatchGetFragments", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		// More ids than are looked up in one query
+		ids := []string{}
+		for i := 0; i < 600; i++ {
+			frag := testutils.CreateTestFragment()
+			frag.Id = strconv.Itoa(i)
+			store.WriteFragment(&frag)
+			ids = append(ids, frag.Id)
+		}
+		ids = append(ids, "missing")
+
+		results := store.BatchGetFragments("user", ids)
+		assert.Equal(t, len(ids), len(results))
+		for i, result := range results[:600] {
+			assert.NoError(t, result.Err)
+			assert.Equal(t, strconv.Itoa(i), result.Fragment.Id)
+		}
+		assert.Nil(t, results[600].Fragment)
+	})
+
+	t.Run("TestListFragments", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		frag := testutils.CreateTestFragment()
+		store.WriteFragment(&frag)
+		trashed := testutils.CreateTestFragment()
+		trashed.Id = "trashed"
+		trashed.Deleted = &trashed.Updated
+		store.WriteFragment(&trashed)
+
+		listed, err := store.ListFragments("user", fragment.ListOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(listed.Fragments))
+		assert.Equal(t, frag.Id, listed.Fragments[0].Id)
+		assert.Equal(t, frag.Size, listed.Fragments[0].Size)
+	})
+
+	t.Run("TestDeleteFragment", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		frag := testutils.CreateTestFragment()
+		store.WriteFragment(&frag)
+		assert.NoError(t, store.DeleteFragment(frag.OwnerId, frag.Id))
+		retrievedFrag, _ := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.Nil(t, retrievedFrag)
+	})
+
+	t.Run("TestReopenKeepsData", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "fragments.db")
+		store, err := fragment.NewSQLiteStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frag := testutils.CreateTestFragment()
+		store.WriteFragment(&frag)
+		store.Close()
+
+		// Migrations that were already applied must not run again
+		store, err = fragment.NewSQLiteStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		retrievedFrag, _ := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.NotNil(t, retrievedFrag)
+	})
+
+	t.Run("TestFragmentVersions", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		frag := testutils.CreateTestFragment()
+		for version := 2; version >= 1; version-- {
+			frag.Version = version
+			assert.NoError(t, store.WriteFragmentVersion(&frag))
+		}
+
+		versions, err := store.GetFragmentVersions(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(versions))
+		assert.Equal(t, 1, versions[0].Version)
+
+		version, err := store.GetFragmentVersion(frag.OwnerId, frag.Id, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version.Version)
+
+		assert.NoError(t, store.DeleteFragmentVersion(frag.OwnerId, frag.Id, 2))
+		version, err = store.GetFragmentVersion(frag.OwnerId, frag.Id, 2)
+		assert.NoError(t, err)
+		assert.Nil(t, version)
+	})
+
+	t.Run("TestTrashedFragments", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		frag := testutils.CreateTestFragment()
+		deleted := time.Now()
+		frag.Deleted = &deleted
+		assert.NoError(t, store.WriteFragment(&frag))
+
+		ids, err := store.GetFragmentIds(frag.OwnerId)
+		assert.NoError(t, err)
+		assert.Empty(t, ids)
+		trashed, err := store.GetTrashedFragments(frag.OwnerId)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(trashed))
+		assert.True(t, deleted.Equal(*trashed[0].Deleted))
+
+		frag.Deleted = nil
+		assert.NoError(t, store.WriteFragment(&frag))
+		ids, _ = store.GetFragmentIds(frag.OwnerId)
+		assert.Equal(t, []string{frag.Id}, ids)
+	})
+
+	t.Run("TestBlobRefs", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		refs, err := store.AddBlobRefs("user", "sha256-abc", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, refs)
+		refs, _ = store.AddBlobRefs("user", "sha256-abc", 1)
+		assert.Equal(t, 2, refs)
+		refs, _ = store.GetBlobRefs("user", "sha256-abc")
+		assert.Equal(t, 2, refs)
+
+		store.AddBlobRefs("user", "sha256-abc", -2)
+		refs, err = store.GetBlobRefs("user", "sha256-abc")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, refs)
+
+		claimed, err := store.ClaimBlobDeletion("user", "sha256-abc")
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+		claimed, _ = store.ClaimBlobDeletion("user", "sha256-abc")
+		assert.False(t, claimed)
+		_, err = store.AddBlobRefs("user", "sha256-abc", 1)
+		assert.ErrorIs(t, err, fragment.ErrBlobDeleting)
+		refs, _ = store.GetBlobRefs("user", "sha256-abc")
+		assert.Equal(t, 0, refs)
+		assert.NoError(t, store.EndBlobDeletion("user", "sha256-abc"))
+		refs, _ = store.AddBlobRefs("user", "sha256-abc", 1)
+		assert.Equal(t, 1, refs)
+	})
+
+	t.Run("TestDataKeys", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		first := fragment.DataKey{OwnerId: "user", Id: "1", WrappedKey: []byte("wrapped"), MasterKeyId: "old", Created: time.Now()}
+		second := fragment.DataKey{OwnerId: "user", Id: "2", WrappedKey: []byte("wrapped"), MasterKeyId: "old", Created: time.Now().Add(time.Second)}
+		assert.NoError(t, store.WriteDataKey(&second))
+		assert.NoError(t, store.WriteDataKey(&first))
+
+		keys, err := store.GetDataKeys("user")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(keys))
+		assert.Equal(t, "1", keys[0].Id)
+		assert.Equal(t, []byte("wrapped"), keys[0].WrappedKey)
+
+		first.MasterKeyId = "new"
+		assert.NoError(t, store.WriteDataKey(&first))
+		keys, _ = store.ListDataKeys()
+		assert.Equal(t, 2, len(keys))
+		assert.Equal(t, "new", keys[0].MasterKeyId)
+	})
+}
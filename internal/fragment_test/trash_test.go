@@ -0,0 +1,142 @@
+package fragment_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrash(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestTrashAndRestore", func(t *testing.T) {
+		fragment.ResetDB()
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		trashed, err := fragment.TrashFragment(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.NotNil(t, trashed.Deleted)
+		retrievedFrag, _ := fragment.GetFragment(frag.OwnerId, frag.Id)
+		assert.Nil(t, retrievedFrag)
+		ids, _ := fragment.GetUserFragmentIds(frag.OwnerId)
+		assert.Empty(t, ids)
+		trash, _ := fragment.GetTrashedFragments(frag.OwnerId)
+		assert.Equal(t, 1, len(trash))
+
+		restored, err := fragment.RestoreFragment(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Nil(t, restored.Deleted)
+		retrievedFrag, _ = fragment.GetFragment(frag.OwnerId, frag.Id)
+		data, _ := retrievedFrag.GetData()
+		assert.Equal(t, []byte("Sample data"), data)
+		trash, _ = fragment.GetTrashedFragments(frag.OwnerId)
+		assert.Empty(t, trash)
+	})
+
+	t.Run("TestTrashNonExistentFragment", func(t *testing.T) {
+		fragment.ResetDB()
+		trashed, err := fragment.TrashFragment("user", "missing")
+		assert.NoError(t, err)
+		assert.Nil(t, trashed)
+		restored, err := fragment.RestoreFragment("user", "missing")
+		assert.NoError(t, err)
+		assert.Nil(t, restored)
+	})
+
+	t.Run("TestUpdateTrashedFragment", func(t *testing.T) {
+		fragment.ResetDB()
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		fragment.TrashFragment(frag.OwnerId, frag.Id)
+		assert.ErrorIs(t, frag.SetData([]byte("Updated data")), fragment.ErrFragmentInTrash)
+	})
+
+	t.Run("TestWriteDoesNotUndoConcurrentTrash", func(t *testing.T) {
+		store := setupInterleavingStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		store.interleave = func() {
+			trashed, err := fragment.TrashFragment(frag.OwnerId, frag.Id)
+			assert.NoError(t, err)
+			assert.NotNil(t, trashed)
+		}
+		assert.ErrorIs(t, frag.SetData([]byte("Updated data")), fragment.ErrFragmentInTrash)
+		trashed, _ := fragment.GetTrashedFragments(frag.OwnerId)
+		assert.Equal(t, 1, len(trashed))
+	})
+
+	t.Run("TestTrashPreconditionIsCheckedByTheWrite", func(t *testing.T) {
+		store := setupInterleavingStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		read := frag.ETag()
+		unchanged := func(current *fragment.Fragment) bool { return current != nil && current.ETag() == read }
+
+		store.interleave = func() {
+			update := testutils.CreateTestFragment()
+			assert.NoError(t, update.SetData([]byte("Updated data")))
+		}
+		trashed, err := fragment.TrashFragmentIf(frag.OwnerId, frag.Id, unchanged)
+		assert.ErrorIs(t, err, fragment.ErrPreconditionFailed)
+		assert.Nil(t, trashed)
+		current, _ := fragment.GetFragment(frag.OwnerId, frag.Id)
+		assert.NotNil(t, current)
+	})
+
+	t.Run("TestPurgeOnlyTrashedFragments", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+
+		purged, err := fragment.PurgeFragment(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, false, purged)
+
+		fragment.TrashFragment(frag.OwnerId, frag.Id)
+		purged, err = fragment.PurgeFragment(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, true, purged)
+		retrievedFrag, _ := store.GetFragment(frag.OwnerId, frag.Id)
+		assert.Nil(t, retrievedFrag)
+		blobs, _ := store.ListFragmentData(frag.OwnerId)
+		assert.Empty(t, blobs)
+	})
+
+	t.Run("TestPurgeExpiredTrash", func(t *testing.T) {
+		store := testutils.SetupMemoryStore(t)
+		expired := testutils.CreateTestFragment()
+		assert.NoError(t, expired.SetData([]byte("Expired")))
+		deleted := time.Now().Add(-2 * time.Hour)
+		expired.Deleted = &deleted
+		store.WriteFragment(&expired)
+
+		recent := testutils.CreateTestFragment()
+		recent.Id = "2"
+		assert.NoError(t, recent.SetData([]byte("Recent")))
+		fragment.TrashFragment(recent.OwnerId, recent.Id)
+
+		purged, err := fragment.PurgeExpiredTrash(time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		trash, _ := fragment.GetTrashedFragments(recent.OwnerId)
+		assert.Equal(t, 1, len(trash))
+		assert.Equal(t, recent.Id, trash[0].Id)
+	})
+
+	t.Run("TestReconcileKeepsTrashedData", func(t *testing.T) {
+		testutils.SetupMemoryStore(t)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Sample data")))
+		fragment.TrashFragment(frag.OwnerId, frag.Id)
+
+		report, err := fragment.Reconcile(fragment.ReconcileOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, report.Discrepancies)
+	})
+}
@@ -0,0 +1,188 @@
+package fragment_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Holds back blob writes until as many of them as the barrier was set up for
+// have started
+type barrierBlobStore struct {
+	*fragment.MemoryStore
+	barrier *sync.WaitGroup
+}
+
+func (store *barrierBlobStore) WriteFragmentData(ownerId string, id string, data io.Reader) (int64, error) {
+	if store.barrier != nil {
+		store.barrier.Done()
+		store.barrier.Wait()
+	}
+	return store.MemoryStore.WriteFragmentData(ownerId, id, data)
+}
+
+func TestFragmentVersions(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	t.Run("TestEachWriteCreatesAVersion", func(t *testing.T) {
+		fragment.ResetDB()
+		frag := testutils.CreateTestFragment()
+		created := frag.Created
+		assert.NoError(t, frag.SetData([]byte("First")))
+		assert.NoError(t, frag.SetData([]byte("Second")))
+		assert.Equal(t, 2, frag.Version)
+		assert.True(t, created.Equal(frag.Created))
+
+		versions, err := fragment.GetFragmentVersions(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(versions))
+		data, _ := versions[0].GetData()
+		assert.Equal(t, []byte("First"), data)
+		data, _ = versions[1].GetData()
+		assert.Equal(t, []byte("Second"), data)
+	})
+
+	t.Run("TestLegacyFragmentKeepsItsData", func(t *testing.T) {
+		fragment.ResetDB()
+		frag := testutils.CreateTestFragment()
+		// Written before versioning: data stored under the id and no version
+		fragment.WriteFragmentData(frag.OwnerId, frag.Id, bytes.NewReader([]byte("Legacy")))
+		frag.Save()
+
+		assert.NoError(t, frag.SetData([]byte("Updated")))
+		assert.Equal(t, 2, frag.Version)
+		legacy, err := fragment.GetFragmentVersion(frag.OwnerId, frag.Id, 1)
+		assert.NoError(t, err)
+		data, _ := legacy.GetData()
+		assert.Equal(t, []byte("Legacy"), data)
+	})
+
+	t.Run("TestRollback", func(t *testing.T) {
+		fragment.ResetDB()
+		frag := testutils.CreateTestFragment()
+		frag.FragmentType = "text/plain"
+		assert.NoError(t, frag.SetData([]byte("First")))
+		frag.FragmentType = "text/markdown"
+		assert.NoError(t, frag.SetData([]byte("# Second")))
+
+		rolledBack, err := fragment.RollbackFragment(frag.OwnerId, frag.Id, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, rolledBack.Version)
+		assert.Equal(t, "text/plain", rolledBack.FragmentType)
+		current, _ := fragment.GetFragment(frag.OwnerId, frag.Id)
+		data, _ := current.GetData()
+		assert.Equal(t, []byte("First"), data)
+
+		_, err = fragment.RollbackFragment(frag.OwnerId, frag.Id, 10)
+		assert.ErrorIs(t, err, fragment.ErrVersionNotFound)
+	})
+
+	// Concurrent writers each get a version of their own, none is overwritten.
+	// With fewer writers than commit attempts every write succeeds.
+	concurrentWrites := func(t *testing.T, metadata fragment.FragmentStore) {
+		blobs := &barrierBlobStore{MemoryStore: fragment.NewMemoryStore()}
+		fragment.SetStores(metadata, blobs)
+		t.Cleanup(fragment.ResetDB)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Original")))
+
+		const writers = 4
+		// Every writer reads the original as its previous version before any of them commits
+		blobs.barrier = &sync.WaitGroup{}
+		blobs.barrier.Add(writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				update := testutils.CreateTestFragment()
+				assert.NoError(t, update.SetData([]byte(fmt.Sprintf("Writer %d", i))))
+			}(i)
+		}
+		wg.Wait()
+
+		current, err := fragment.GetFragment(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, writers+1, current.Version)
+		versions, err := fragment.GetFragmentVersions(frag.OwnerId, frag.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, writers+1, len(versions))
+		seen := map[string]bool{}
+		for i, version := range versions {
+			assert.Equal(t, i+1, version.Version)
+			data, err := version.GetData()
+			assert.NoError(t, err)
+			seen[string(data)] = true
+		}
+		assert.Equal(t, writers+1, len(seen))
+		// Every blob belongs to a version
+		stored, _ := blobs.ListFragmentData(frag.OwnerId)
+		assert.Equal(t, writers+1, len(stored))
+	}
+
+	t.Run("TestConcurrentWritesInMemory", func(t *testing.T) {
+		concurrentWrites(t, fragment.NewMemoryStore())
+	})
+
+	t.Run("TestConcurrentWritesInSQLite", func(t *testing.T) {
+		concurrentWrites(t, newTestSQLiteStore(t))
+	})
+
+	t.Run("TestOnlyOneConditionalWriteWins", func(t *testing.T) {
+		blobs := &barrierBlobStore{MemoryStore: fragment.NewMemoryStore()}
+		metadata := fragment.NewMemoryStore()
+		fragment.SetStores(metadata, blobs)
+		t.Cleanup(fragment.ResetDB)
+		frag := testutils.CreateTestFragment()
+		assert.NoError(t, frag.SetData([]byte("Original")))
+		read := frag.ETag()
+		unchanged := func(current *fragment.Fragment) bool { return current != nil && current.ETag() == read }
+
+		// Both writers pass the precondition before either of them commits
+		const writers = 2
+		blobs.barrier = &sync.WaitGroup{}
+		blobs.barrier.Add(writers)
+		errs := make([]error, writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				update := testutils.CreateTestFragment()
+				errs[i] = update.WriteDataIf(bytes.NewReader([]byte(fmt.Sprintf("Writer %d", i))), unchanged)
+			}(i)
+		}
+		wg.Wait()
+
+		failed := 0
+		for _, err := range errs {
+			if err != nil {
+				assert.ErrorIs(t, err, fragment.ErrPreconditionFailed)
+				failed++
+			}
+		}
+		assert.Equal(t, 1, failed)
+		versions, _ := fragment.GetFragmentVersions(frag.OwnerId, frag.Id)
+		assert.Equal(t, 2, len(versions))
+		// The losing write's blob was removed
+		stored, _ := blobs.ListFragmentData(frag.OwnerId)
+		assert.Equal(t, 2, len(stored))
+	})
+
+	t.Run("TestDeleteRemovesVersions", func(t *testing.T) {
+		fragment.ResetDB()
+		frag := testutils.CreateTestFragment()
+		frag.SetData([]byte("First"))
+		frag.SetData([]byte("Second"))
+		assert.Equal(t, true, fragment.DeleteFragment(frag.OwnerId, frag.Id))
+		versions, _ := fragment.GetFragmentVersions(frag.OwnerId, frag.Id)
+		assert.Empty(t, versions)
+	})
+}
@@ -1,14 +1,19 @@
 package memorydb
 
 import (
-	"github.com/Jashanpreet2/fragments/internal/logger"
+	"sync"
 )
 
+// LocalDB is an in-memory key value store using a partition key and a sort key.
+// It is safe for concurrent use.
 type LocalDB struct {
+	mu     sync.RWMutex
 	db_map map[string]map[string]any
 }
 
 func (db *LocalDB) Put(pk string, sk string, val any) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	if db.db_map == nil {
 		db.db_map = map[string]map[string]any{}
 	}
@@ -19,7 +24,22 @@ func (db *LocalDB) Put(pk string, sk string, val any) bool {
 	return true
 }
 
+// Returns the partition keys that hold at least one value
+func (db *LocalDB) GetPKs() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	pks := []string{}
+	for pk, values := range db.db_map {
+		if len(values) > 0 {
+			pks = append(pks, pk)
+		}
+	}
+	return pks
+}
+
 func (db *LocalDB) GetSKs(pk string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	if db.db_map[pk] == nil {
 		return []string{}
 	}
@@ -33,8 +53,8 @@ func (db *LocalDB) GetSKs(pk string) []string {
 }
 
 func (db *LocalDB) GetValue(pk string, sk string) (any, bool) {
-	logger.Sugar.Info("Finding a value in the following map:")
-	logger.Sugar.Info(db.db_map)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	if db.db_map[pk] != nil && db.db_map[pk][sk] != nil {
 		return db.db_map[pk][sk], true
 	}
@@ -42,9 +62,18 @@ func (db *LocalDB) GetValue(pk string, sk string) (any, bool) {
 }
 
 func (db *LocalDB) DeleteValue(pk string, sk string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	if db.db_map[pk] != nil && db.db_map[pk][sk] != nil {
 		delete(db.db_map[pk], sk)
 		return true
 	}
 	return false
 }
+
+// Removes every value from the database
+func (db *LocalDB) Reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.db_map = nil
+}
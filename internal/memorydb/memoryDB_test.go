@@ -1,6 +1,8 @@
 package memorydb
 
 import (
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -54,4 +56,29 @@ func TestMemoryDB(t *testing.T) {
 		testDB.Put(pk, sk, value)
 		assert.Equal(t, []string{sk}, testDB.GetSKs(pk))
 	})
+
+	t.Run("TestConcurrentAccess", func(t *testing.T) {
+		testDB := LocalDB{}
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				sk := strconv.Itoa(i)
+				testDB.Put("TestPK", sk, i)
+				testDB.GetValue("TestPK", sk)
+				testDB.GetSKs("TestPK")
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, 50, len(testDB.GetSKs("TestPK")))
+	})
+
+	t.Run("TestReset", func(t *testing.T) {
+		testDB := LocalDB{}
+		testDB.Put("TestPK", "TestSK", "TestValue")
+		testDB.Reset()
+		_, ok := testDB.GetValue("TestPK", "TestSK")
+		assert.Equal(t, false, ok)
+	})
 }
@@ -2,9 +2,13 @@ package testutils
 
 import (
 	"bytes"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
 	"time"
 
 	"github.com/Jashanpreet2/fragments/internal/config"
@@ -22,8 +26,14 @@ func PreTestSetup(mode string) func() {
 	} else {
 		os.Args[len(os.Args)-1] = mode
 	}
+	// Keep the tests hermetic by using the in-memory backends
+	os.Setenv("FRAGMENTS_METADATA_BACKEND", "memory")
+	os.Setenv("FRAGMENTS_DATA_BACKEND", "memory")
+	os.Setenv("FRAGMENTS_SEARCH_INDEX_PATH", ":memory:")
 	if mode == "debug" {
-		godotenv.Load("../../")
+		// Load the env file from the repository root regardless of the package being tested
+		_, file, _, _ := runtime.Caller(0)
+		godotenv.Load(filepath.Join(filepath.Dir(file), "..", "..", ".env.debug"))
 	}
 	config.Config()
 	return func() {
@@ -31,6 +41,15 @@ func PreTestSetup(mode string) func() {
 	}
 }
 
+// Uses a fresh in-memory store for both the metadata and the data until the
+// test ends.
+func SetupMemoryStore(t *testing.T) *fragment.MemoryStore {
+	store := fragment.NewMemoryStore()
+	fragment.SetStores(store, store)
+	t.Cleanup(fragment.ResetDB)
+	return store
+}
+
 func CreateTestFragment() fragment.Fragment {
 	return fragment.Fragment{Id: "1", OwnerId: "user", Created: time.Now(), Updated: time.Now(), FragmentType: "text", Size: 5}
 }
@@ -48,3 +67,31 @@ func PostFragment(r *gin.Engine, data []byte, mimeType string, username string,
 
 	return w
 }
+
+func PutFragment(r *gin.Engine, id string, data []byte, mimeType string, username string, password string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("PUT", "/v1/fragments/"+id, bytes.NewReader(data))
+	req.Header.Add("Content-Type", mimeType)
+	req.SetBasicAuth(username, password)
+
+	r.ServeHTTP(w, req)
+
+	return w
+}
+
+// Writes the data to the blob store in one go.
+func WriteBlob(store fragment.BlobStore, ownerId string, id string, data []byte) error {
+	_, err := store.WriteFragmentData(ownerId, id, bytes.NewReader(data))
+	return err
+}
+
+// Reads a whole blob from the blob store.
+func ReadBlob(store fragment.BlobStore, ownerId string, id string) ([]byte, error) {
+	reader, err := store.ReadFragmentData(ownerId, id)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Elements whose contents are never shown as text
+var hiddenElements = map[string]bool{
+	"head": true, "title": true, "script": true, "style": true, "template": true, "noscript": true, "svg": true,
+}
+
+// Elements that start a new paragraph, separated from the text around them by a blank line
+var paragraphElements = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "pre": true,
+	"blockquote": true, "table": true, "ul": true, "ol": true, "dl": true, "figure": true, "hr": true,
+}
+
+// Elements that start a new line
+var lineElements = map[string]bool{
+	"div": true, "section": true, "article": true, "header": true, "footer": true, "nav": true, "main": true,
+	"aside": true, "li": true, "tr": true, "dt": true, "dd": true, "figcaption": true, "address": true,
+}
+
+// Builds the text of an HTML document, collapsing whitespace the way a browser would.
+type textWriter struct {
+	out bytes.Buffer
+	// Line breaks and the space waiting to be written before the next text
+	newlines int
+	space    bool
+	// Depth of pre elements, whose whitespace is kept
+	pre int
+}
+
+func (w *textWriter) breakLines(n int) {
+	if n == 0 {
+		return
+	}
+	if w.out.Len() > 0 && n > w.newlines {
+		w.newlines = n
+	}
+	w.space = false
+}
+
+// Writes the text as is after the pending line breaks or space.
+func (w *textWriter) write(text string) {
+	if text == "" {
+		return
+	}
+	if w.newlines > 0 {
+		w.out.WriteString(strings.Repeat("\n", w.newlines))
+	} else if w.space {
+		w.out.WriteByte(' ')
+	}
+	w.newlines, w.space = 0, false
+	w.out.WriteString(text)
+}
+
+// Writes text content, collapsing its whitespace outside of pre elements.
+func (w *textWriter) text(text string) {
+	if w.pre > 0 {
+		w.write(text)
+		return
+	}
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		w.space = w.space || text != "" && w.out.Len() > 0
+		return
+	}
+	if text[0] == ' ' || text[0] == '\t' || text[0] == '\n' || text[0] == '\r' {
+		w.space = w.out.Len() > 0
+	}
+	w.write(strings.Join(words, " "))
+	w.space = strings.TrimRight(text, " \t\r\n") != text
+}
+
+// Converts HTML into plain text. Paragraphs are separated by blank lines, list
+// items are prefixed with a dash or their number, images are replaced by their
+// alt text and links are followed by their address in parentheses.
+func ConvertHtmlToText(data []byte) []byte {
+	w := &textWriter{}
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	hidden := 0
+	// Next number of each open list, 0 for unordered lists
+	lists := []int{}
+	var href string
+	linkStart := -1
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		name := token.Data
+		switch tokenType {
+		case html.TextToken:
+			if hidden == 0 {
+				w.text(token.Data)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if hiddenElements[name] {
+				if tokenType == html.StartTagToken {
+					hidden++
+				}
+				continue
+			}
+			if hidden > 0 {
+				continue
+			}
+			w.breakLines(lineBreaks(name, lists))
+			switch name {
+			case "br":
+				// Consecutive breaks produce empty lines
+				if w.out.Len() > 0 {
+					w.newlines++
+				}
+				w.space = false
+			case "pre":
+				w.pre++
+			case "ul", "ol":
+				start := 0
+				if name == "ol" {
+					start = 1
+					if value, ok := attribute(token, "start"); ok {
+						if n, err := strconv.Atoi(value); err == nil {
+							start = n
+						}
+					}
+				}
+				lists = append(lists, start)
+			case "li":
+				indent := strings.Repeat("  ", max(len(lists)-1, 0))
+				marker := "- "
+				if len(lists) > 0 && lists[len(lists)-1] > 0 {
+					marker = strconv.Itoa(lists[len(lists)-1]) + ". "
+					lists[len(lists)-1]++
+				}
+				w.write(indent + marker)
+			case "td", "th":
+				if w.out.Len() > 0 && w.newlines == 0 {
+					w.space = true
+				}
+			case "img":
+				if alt, ok := attribute(token, "alt"); ok {
+					w.text(alt)
+				}
+			case "a":
+				href, _ = attribute(token, "href")
+				linkStart = w.out.Len()
+			}
+		case html.EndTagToken:
+			if hiddenElements[name] {
+				hidden = max(hidden-1, 0)
+				continue
+			}
+			if hidden > 0 {
+				continue
+			}
+			switch name {
+			case "pre":
+				w.pre = max(w.pre-1, 0)
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+			case "a":
+				if linkStart >= 0 && showLink(href, string(w.out.Bytes()[linkStart:])) {
+					space := w.space
+					w.space = w.out.Len() > linkStart
+					w.write("(" + href + ")")
+					w.space = space
+				}
+				href, linkStart = "", -1
+			}
+			w.breakLines(lineBreaks(name, lists))
+		}
+	}
+
+	text := strings.TrimSpace(w.out.String())
+	if text == "" {
+		return []byte{}
+	}
+	return []byte(text + "\n")
+}
+
+// Returns the number of line breaks around the element, 0 for inline
+// elements. Lists nested in a list item follow it on the next line.
+func lineBreaks(name string, lists []int) int {
+	switch {
+	case (name == "ul" || name == "ol") && len(lists) > 0:
+		return 1
+	case paragraphElements[name]:
+		return 2
+	case lineElements[name]:
+		return 1
+	}
+	return 0
+}
+
+func attribute(token html.Token, name string) (string, bool) {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return strings.TrimSpace(attr.Val), true
+		}
+	}
+	return "", false
+}
+
+// Reports whether a link's address is worth showing after its text. Links
+// within the page and addresses that are already the text are left out.
+func showLink(href string, text string) bool {
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return false
+	}
+	text = strings.TrimSpace(text)
+	return text != href && "mailto:"+text != href && "tel:"+text != href
+}
+
+// Converts markdown into plain text by rendering it and removing the markup.
+func ConvertMdToText(data []byte) []byte {
+	return ConvertHtmlToText(ConvertMdToHtml(data))
+}
+
+// Converts plain text into HTML that shows it unchanged.
+func ConvertTextToHtml(data []byte) []byte {
+	return []byte("<pre>" + html.EscapeString(string(data)) + "</pre>\n")
+}
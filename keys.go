@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+// Rewraps every data key with the current master key so that previous master
+// keys can be retired. Fragment data is left untouched.
+//
+// Usage: fragments rotate-keys debug|prod
+func runRotateKeysCommand() int {
+	rotated, err := fragment.RotateDataKeys()
+	fmt.Printf("Rewrapped %d data keys\n", rotated)
+	if err != nil {
+		logger.Sugar.Error("Key rotation failed: ", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/gin-gonic/gin"
+)
+
+// Pages never hold more fragments than this, larger limits are lowered to it
+const maxListLimit = 1000
+
+// Reads the pagination, filter and sort parameters of GET /fragments:
+//
+//	limit, cursor: page size and the next_cursor of the previous page
+//	type: fragment type without parameters, e.g. text/plain
+//	tag: only fragments with the tag, can be repeated
+//	created_after, created_before, updated_after, updated_before: RFC 3339 times
+//	min_size, max_size: size bounds in bytes
+//	sort: created, updated or size, prefixed with - for descending order
+func parseListOptions(c *gin.Context) (fragment.ListOptions, error) {
+	options := fragment.ListOptions{
+		Cursor: c.Query("cursor"),
+		Type:   c.Query("type"),
+	}
+	tags, err := tagQuery(c)
+	if err != nil {
+		return options, err
+	}
+	options.Tags = tags
+	if limit := c.Query("limit"); limit != "" {
+		if options.Limit, err = strconv.Atoi(limit); err != nil || options.Limit <= 0 {
+			return options, fmt.Errorf("limit must be a positive number")
+		}
+		options.Limit = min(options.Limit, maxListLimit)
+	}
+	if options.CreatedAfter, err = timeQuery(c, "created_after"); err != nil {
+		return options, err
+	}
+	if options.CreatedBefore, err = timeQuery(c, "created_before"); err != nil {
+		return options, err
+	}
+	if options.UpdatedAfter, err = timeQuery(c, "updated_after"); err != nil {
+		return options, err
+	}
+	if options.UpdatedBefore, err = timeQuery(c, "updated_before"); err != nil {
+		return options, err
+	}
+	if options.MinSize, err = sizeQuery(c, "min_size"); err != nil {
+		return options, err
+	}
+	if options.MaxSize, err = sizeQuery(c, "max_size"); err != nil {
+		return options, err
+	}
+
+	sort := c.Query("sort")
+	options.Sort, options.Descending = strings.CutPrefix(sort, "-")
+	if sort != "" && options.Sort != fragment.SortCreated && options.Sort != fragment.SortUpdated && options.Sort != fragment.SortSize {
+		return options, fmt.Errorf("sort must be one of created, updated or size")
+	}
+	return options, nil
+}
+
+func timeQuery(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+	}
+	return parsed, nil
+}
+
+func sizeQuery(c *gin.Context, name string) (int64, error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%s must be a number of bytes", name)
+	}
+	return size, nil
+}
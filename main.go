@@ -2,7 +2,9 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -42,8 +44,9 @@ func SetHeaders() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Range, If-Match, If-None-Match, If-Modified-Since, X-Fragment-Tag")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Location, ETag, Last-Modified, Accept-Ranges, Content-Range")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 		c.Writer.Header().Set("Cache-Control", "no-cache")
 
 		if c.Request.Method == "OPTIONS" {
@@ -155,106 +158,163 @@ func getRouter() *gin.Engine {
 
 	v1.GET("/fragments", func(c *gin.Context) {
 		username := c.GetString("username")
-		logger.Sugar.Info("Content type: ", c.GetHeader("Content-Type"))
-		logger.Sugar.Info(username)
 		if username == "" {
 			logger.Sugar.Info("Request passed through authentication but still failed to retrieve the username")
 			c.JSON(http.StatusBadRequest, gin.H{"message": "Failed to parse username"})
+			return
+		}
+		options, err := parseListOptions(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		page, err := fragment.ListFragments(hashing.HashString(username), options)
+		if errors.Is(err, fragment.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "The cursor is invalid for this listing!"})
+			return
 		}
-		fragmentIds, err := fragment.GetUserFragmentIds(hashing.HashString(username))
 		if err != nil {
 			logger.Sugar.Info(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to retrieve fragment IDs"})
 			return
 		}
-		logger.Sugar.Info(fragmentIds)
-		if c.Query("expand") == "1" {
-			fragments := []*fragment.Fragment{}
-			for _, fragmentId := range fragmentIds {
-				fragment, err := fragment.GetFragment(hashing.HashString(username), fragmentId)
-				if err != nil {
-					logger.Sugar.Info("Failed to find fragment with fragment id " + fragmentId + " for user " + username)
-				} else {
-					fragments = append(fragments, fragment)
-				}
-				fragmentsjson, _ := json.Marshal(fragments)
-				logger.Sugar.Info(string(fragmentsjson))
-			}
-			c.JSON(http.StatusOK, gin.H{"status": "ok", "fragments": fragments})
+
+		response := gin.H{"status": "ok"}
+		if page.NextCursor != "" {
+			response["next_cursor"] = page.NextCursor
+		}
+		fragmentIds := make([]string, 0, len(page.Fragments))
+		for _, listed := range page.Fragments {
+			fragmentIds = append(fragmentIds, listed.Id)
+		}
+		if c.Query("expand") != "1" {
+			response["fragment_ids"] = fragmentIds
+			c.JSON(http.StatusOK, response)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"status": "ok", "fragment_ids": fragmentIds})
+		// Fragments that can't be read are reported next to the ones that could
+		fragments := []*fragment.Fragment{}
+		failed := []gin.H{}
+		for i, result := range fragment.GetFragments(hashing.HashString(username), fragmentIds) {
+			if result.Err != nil {
+				logger.Sugar.Errorf("Failed to retrieve fragment with fragment id %s for user %s: %s", fragmentIds[i], username, result.Err)
+				failed = append(failed, gin.H{"id": fragmentIds[i], "message": "Failed to retrieve the fragment"})
+			} else if result.Fragment != nil {
+				fragments = append(fragments, result.Fragment)
+			}
+		}
+		response["fragments"] = fragments
+		if len(failed) > 0 {
+			response["errors"] = failed
+		}
+		c.JSON(http.StatusOK, response)
 	})
-	v1.POST("/fragments", func(c *gin.Context) {
-		fileData, err := c.GetRawData()
+	v1.GET("/fragments/search", func(c *gin.Context) {
+		username := hashing.HashString(c.GetString("username"))
+		query := c.Query("q")
+		if strings.TrimSpace(query) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "A search query is required!"})
+			return
+		}
+		limit := defaultSearchLimit
+		if value := c.Query("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "limit must be a positive number"})
+				return
+			}
+			limit = min(limit, maxSearchLimit)
+		}
+		results, err := fragment.SearchFragments(username, query, limit)
 		if err != nil {
-			logger.Sugar.Info(err)
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Unable to retrieve file data from the request body!"})
+			logger.Sugar.Error("Search failed: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Server side error"})
+			return
 		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "results": results})
+	})
+	v1.POST("/fragments", func(c *gin.Context) {
 		username := hashing.HashString(c.GetString("username"))
 		fragment_id := fragment.GenerateID()
-		logger.Sugar.Info("File data: ", string(fileData))
 		fragmentType := c.GetHeader("Content-Type")
 		if !fragment.IsSupportedType(fragmentType) {
 			c.JSON(http.StatusUnsupportedMediaType, gin.H{"message": "The specified file format is currently not supported!"})
 			logger.Sugar.Infof("User tried to store fragment of type %s", fragmentType)
 			return
 		}
-		fragment := fragment.Fragment{
+		tags, metadata, err := parseMetadataHeaders(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		fileData, ok := fragmentBody(c, username, fragment_id)
+		if !ok {
+			return
+		}
+		frag := fragment.Fragment{
 			Id:      fragment_id,
 			OwnerId: username, Created: time.Now(),
 			Updated:      time.Now(),
 			FragmentType: fragmentType,
-			Size:         len(fileData)}
-		fragment.SetData(fileData)
-		logger.Sugar.Infof("File data being saved: %s", fileData)
-		fragment.Save()
+			Tags:         tags,
+			Metadata:     metadata}
+		if err := frag.CreateData(fileData); err != nil {
+			writeSaveError(c, err)
+			return
+		}
 
 		scheme := "http://"
 		if c.Request.TLS != nil {
 			scheme = "https://"
 		}
 
-		c.Header("Location", scheme+c.Request.Host+fmt.Sprintf("/v1/fragment/%s", fragment.Id))
+		c.Header("Location", scheme+c.Request.Host+fmt.Sprintf("/v1/fragment/%s", frag.Id))
+		c.Header("ETag", frag.ETag())
 		c.JSON(http.StatusCreated, gin.H{"status": "ok", "message": "Fragment has successfully been saved",
-			"fragment": fragment})
+			"fragment": frag})
 		// c.JSON(http.StatusOK, gin.H{"abc": "asja"})
 		c.Abort()
 	})
 	v1.PUT("/fragments/:id", func(c *gin.Context) {
 		fragment_id := c.Param("id")
-		fileData, err := c.GetRawData()
-		if err != nil {
-			logger.Sugar.Info(err)
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Unable to retrieve file data from the request body!"})
-		}
 		username := hashing.HashString(c.GetString("username"))
-		logger.Sugar.Info("File data: ", string(fileData))
 		fragmentType := c.GetHeader("Content-Type")
 		if !fragment.IsSupportedType(fragmentType) {
 			c.JSON(http.StatusUnsupportedMediaType, gin.H{"message": "The specified file format is currently not supported!"})
 			logger.Sugar.Infof("User tried to store fragment of type %s", fragmentType)
 			return
 		}
-		fragment := fragment.Fragment{
+		tags, metadata, err := parseMetadataHeaders(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		fileData, ok := fragmentBody(c, username, fragment_id)
+		if !ok {
+			return
+		}
+		frag := fragment.Fragment{
 			Id:      fragment_id,
 			OwnerId: username, Created: time.Now(),
 			Updated:      time.Now(),
 			FragmentType: fragmentType,
-			Size:         len(fileData)}
-		fragment.SetData(fileData)
-		logger.Sugar.Infof("File data being saved: %s", fileData)
-		fragment.Save()
+			Tags:         tags,
+			Metadata:     metadata}
+		if err := frag.WriteDataIf(fileData, ifMatch(c)); err != nil {
+			writeSaveError(c, err)
+			return
+		}
 
 		scheme := "http://"
 		if c.Request.TLS != nil {
 			scheme = "https://"
 		}
 
-		c.Header("Location", scheme+c.Request.Host+fmt.Sprintf("/v1/fragment/%s", fragment.Id))
+		c.Header("Location", scheme+c.Request.Host+fmt.Sprintf("/v1/fragment/%s", frag.Id))
+		c.Header("ETag", frag.ETag())
 		c.JSON(http.StatusCreated, gin.H{"status": "ok", "message": "Fragment has successfully been updated",
-			"fragment": fragment})
+			"fragment": frag})
 		// c.JSON(http.StatusOK, gin.H{"abc": "asja"})
 		c.Abort()
 	})
@@ -283,25 +343,48 @@ func getRouter() *gin.Engine {
 			return
 		}
 		logger.Sugar.Info("File type: ", frag.MimeType())
-		var fileData []byte
-		var mimeType string
 		logger.Sugar.Info("Extension: ", ext)
+		// Without an extension the type is negotiated from the Accept header
+		var format string
 		if ext == "" {
-			fileData, err = frag.GetData()
-			mimeType = frag.MimeType()
-			if err != nil {
-				logger.Sugar.Info("Failed to find the fragment")
-				c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to find the fragment data"})
+			c.Header("Vary", "Accept")
+			var ok bool
+			if format, ok = negotiateFormat(c.GetHeader("Accept"), frag.Formats()); !ok {
+				c.JSON(http.StatusNotAcceptable, gin.H{"message": "The fragment isn't available in any of the accepted types",
+					"formats": frag.Formats()})
 				return
 			}
-		} else {
-			fileData, mimeType, err = frag.ConvertMimetype(ext)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Failed to convert!"})
-				return
+			if frag.IsType(format) {
+				format = ""
 			}
 		}
-		logger.Sugar.Info("Data in file: ", string(fileData))
+		variant := ext
+		if format != "" {
+			variant = "+" + format
+		}
+		if notModified(c, representationETag(frag, variant), frag.Updated) {
+			return
+		}
+		if variant == "" {
+			writeFragmentData(c, frag)
+			return
+		}
+		var fileData []byte
+		var mimeType string
+		if ext != "" {
+			fileData, mimeType, err = frag.ConvertMimetype(ext)
+		} else {
+			fileData, mimeType, err = frag.ConvertTo(format)
+		}
+		if errors.Is(err, fragment.ErrUnsupportedConversion) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Failed to convert!", "formats": frag.Formats()})
+			return
+		}
+		if err != nil {
+			logger.Sugar.Error("Failed to convert the fragment: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal Server Error"})
+			return
+		}
 		c.Header("Content-Length", strconv.Itoa(len(fileData)))
 		c.Data(200, mimeType, fileData)
 	})
@@ -309,16 +392,114 @@ func getRouter() *gin.Engine {
 	v1.GET("/fragment/:id/info", func(c *gin.Context) {
 		id := c.Param("id")
 
-		fragment, err := fragment.GetFragment(hashing.HashString(c.GetString("username")), id)
+		frag, err := fragment.GetFragment(hashing.HashString(c.GetString("username")), id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Server side error"})
+			return
+		}
+		if frag == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified fragment!"})
+			return
+		}
+		if notModified(c, frag.InfoETag(), frag.Updated) {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "fragment": frag, "formats": frag.Formats()})
+	})
+
+	v1.GET("/fragment/:id/versions", func(c *gin.Context) {
+		id := c.Param("id")
+		username := hashing.HashString(c.GetString("username"))
+
+		frag, err := fragment.GetFragment(username, id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"message": "Server side error"})
+			return
 		}
-		if fragment == nil {
+		if frag == nil {
 			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified fragment!"})
 			return
 		}
+		versions, err := fragment.GetFragmentVersions(username, id)
+		if err != nil {
+			logger.Sugar.Error("Failed to retrieve fragment versions: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to retrieve the fragment versions"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "versions": versions})
+	})
+
+	v1.GET("/fragment/:id/versions/:version", func(c *gin.Context) {
+		frag, ok := getVersionFromRequest(c)
+		if !ok {
+			return
+		}
+		writeFragmentData(c, frag)
+	})
+
+	v1.GET("/fragment/:id/versions/:version/info", func(c *gin.Context) {
+		frag, ok := getVersionFromRequest(c)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "fragment": frag})
+	})
 
-		c.JSON(http.StatusOK, gin.H{"status": "ok", "fragment": fragment})
+	v1.POST("/fragments/:id/versions/:version/rollback", func(c *gin.Context) {
+		id := c.Param("id")
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Please enter a valid version number!"})
+			return
+		}
+		username := hashing.HashString(c.GetString("username"))
+
+		frag, err := fragment.RollbackFragment(username, id, version)
+		if errors.Is(err, fragment.ErrVersionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified version!"})
+			return
+		}
+		if err != nil {
+			logger.Sugar.Error("Failed to roll back the fragment: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to roll back the fragment"})
+			return
+		}
+		if frag == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified fragment!"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Fragment has been rolled back to version " + strconv.Itoa(version),
+			"fragment": frag})
+	})
+
+	v1.PATCH("/fragments/:id/metadata", func(c *gin.Context) {
+		fragment_id := c.Param("id")
+		username := hashing.HashString(c.GetString("username"))
+		var update fragment.MetadataUpdate
+		if err := json.NewDecoder(c.Request.Body).Decode(&update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "The body must be a JSON object with tags and metadata!"})
+			return
+		}
+		frag, err := fragment.UpdateFragmentMetadataIf(username, fragment_id, update, ifMatchInfo(c))
+		if errors.Is(err, fragment.ErrInvalidMetadata) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		if err != nil && (writeNameError(c, err) || writeConditionError(c, err)) {
+			return
+		}
+		if err != nil {
+			logger.Sugar.Error("Failed to update the fragment metadata: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to update the fragment metadata"})
+			return
+		}
+		if frag == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified fragment!"})
+			return
+		}
+		c.Header("ETag", frag.InfoETag())
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "fragment": frag})
 	})
 
 	v1.DELETE("/fragments/:id", func(c *gin.Context) {
@@ -329,15 +510,289 @@ func getRouter() *gin.Engine {
 			return
 		}
 		username := c.GetString("username")
-		fragment.DeleteFragment(hashing.HashString(username), fragment_id)
-		c.JSON(http.StatusAccepted, gin.H{"message": "Fragment with ID" + fragment_id + " has been deleted!"})
+		frag, err := fragment.TrashFragmentIf(hashing.HashString(username), fragment_id, ifMatch(c))
+		if err != nil && writeConditionError(c, err) {
+			return
+		}
+		if err != nil {
+			logger.Sugar.Error("Failed to move the fragment to the trash: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to delete the fragment"})
+			return
+		}
+		if frag == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified fragment!"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Fragment with ID" + fragment_id + " has been moved to the trash!"})
+	})
+
+	v1.GET("/path/*path", func(c *gin.Context) {
+		frag, err := fragment.GetFragmentByPath(hashing.HashString(c.GetString("username")), c.Param("path"))
+		if err != nil {
+			logger.Sugar.Error("Failed to resolve the fragment path: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Server side error"})
+			return
+		}
+		if frag == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find a fragment at the specified path!"})
+			return
+		}
+		if pathNotModified(c, frag) {
+			return
+		}
+		writeFragmentData(c, frag)
+	})
+
+	v1.GET("/collections", func(c *gin.Context) {
+		collections, err := fragment.GetCollections(hashing.HashString(c.GetString("username")))
+		if err != nil {
+			writeCollectionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "collections": collections})
+	})
+
+	v1.POST("/collections", func(c *gin.Context) {
+		var request collectionRequest
+		if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || request.Name == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "The body must be a JSON object with a name!"})
+			return
+		}
+		parentId := ""
+		if request.ParentId != nil {
+			parentId = *request.ParentId
+		}
+		collection, err := fragment.CreateCollection(hashing.HashString(c.GetString("username")), parentId, *request.Name)
+		if err != nil {
+			writeCollectionError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"status": "ok", "collection": collection})
+	})
+
+	// Lists the collections and fragments held by a collection
+	v1.GET("/collections/:id", func(c *gin.Context) {
+		username := hashing.HashString(c.GetString("username"))
+		collection, err := fragment.GetCollection(username, c.Param("id"))
+		if err != nil {
+			writeCollectionError(c, err)
+			return
+		}
+		if collection == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified collection!"})
+			return
+		}
+		listing, err := fragment.ListCollection(username, collection.Id)
+		if err != nil {
+			writeCollectionError(c, err)
+			return
+		}
+		fragments := []*fragment.Fragment{}
+		for i, result := range fragment.GetFragments(username, listing.FragmentIds) {
+			if result.Err != nil {
+				logger.Sugar.Errorf("Failed to retrieve fragment with fragment id %s for user %s: %s", listing.FragmentIds[i], username, result.Err)
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to retrieve the fragments"})
+				return
+			}
+			if result.Fragment != nil {
+				fragments = append(fragments, result.Fragment)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "collection": collection, "collections": listing.Collections,
+			"fragments": fragments})
+	})
+
+	// Renames a collection or moves it into another one
+	v1.PATCH("/collections/:id", func(c *gin.Context) {
+		username := hashing.HashString(c.GetString("username"))
+		var request collectionRequest
+		if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "The body must be a JSON object with a name or a parentId!"})
+			return
+		}
+		current, err := fragment.GetCollection(username, c.Param("id"))
+		if err != nil {
+			writeCollectionError(c, err)
+			return
+		}
+		if current == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified collection!"})
+			return
+		}
+		name, parentId := current.Name, current.ParentId
+		if request.Name != nil {
+			name = *request.Name
+		}
+		if request.ParentId != nil {
+			parentId = *request.ParentId
+		}
+		collection, err := fragment.MoveCollection(username, current.Id, parentId, name)
+		if err != nil {
+			writeCollectionError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "collection": collection})
+	})
+
+	v1.DELETE("/collections/:id", func(c *gin.Context) {
+		deleted, err := fragment.DeleteCollection(hashing.HashString(c.GetString("username")), c.Param("id"))
+		if err != nil {
+			writeCollectionError(c, err)
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified collection!"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "The collection has been deleted!"})
+	})
+
+	v1.GET("/usage", func(c *gin.Context) {
+		usage, err := fragment.GetUsage(hashing.HashString(c.GetString("username")))
+		if err != nil {
+			logger.Sugar.Error("Failed to compute the storage usage: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to retrieve the storage usage"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "usage": usage, "limits": fragment.GetLimits()})
+	})
+
+	v1.GET("/trash", func(c *gin.Context) {
+		fragments, err := fragment.GetTrashedFragments(hashing.HashString(c.GetString("username")))
+		if err != nil {
+			logger.Sugar.Error("Failed to retrieve the trash: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to retrieve the trash"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "fragments": fragments})
+	})
+
+	v1.POST("/trash/:id/restore", func(c *gin.Context) {
+		id := c.Param("id")
+		frag, err := fragment.RestoreFragment(hashing.HashString(c.GetString("username")), id)
+		if err != nil {
+			logger.Sugar.Error("Failed to restore the fragment: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to restore the fragment"})
+			return
+		}
+		if frag == nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified fragment in the trash!"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Fragment has been restored", "fragment": frag})
+	})
+
+	v1.DELETE("/trash/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		purged, err := fragment.PurgeFragment(hashing.HashString(c.GetString("username")), id)
+		if err != nil {
+			logger.Sugar.Error("Failed to purge the fragment: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to purge the fragment"})
+			return
+		}
+		if !purged {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified fragment in the trash!"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Fragment with ID " + id + " has been permanently deleted!"})
 	})
 
 	return r
 }
 
+// Checks the request body against the size limits and the user's quota before
+// anything is written, writing an error response when they would be exceeded.
+// The returned reader stops with an error once the maximum fragment size is
+// passed, which catches bodies without a Content-Length.
+func fragmentBody(c *gin.Context, username string, fragment_id string) (io.Reader, bool) {
+	limits := fragment.GetLimits()
+	size := c.Request.ContentLength
+	if size < 0 {
+		if limits.MaxBytes > 0 {
+			c.JSON(http.StatusLengthRequired, gin.H{"message": "A Content-Length is required to check your storage quota!"})
+			return nil, false
+		}
+		size = 0
+	}
+
+	err := fragment.CheckQuota(username, fragment_id, size)
+	if errors.Is(err, fragment.ErrFragmentTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "The fragment exceeds the maximum fragment size!"})
+		return nil, false
+	}
+	if errors.Is(err, fragment.ErrQuotaExceeded) {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"message": "Storing the fragment would exceed your storage quota!"})
+		return nil, false
+	}
+	if err != nil {
+		logger.Sugar.Error("Failed to check the storage quota: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to save the fragment"})
+		return nil, false
+	}
+
+	if limits.MaxFragmentSize > 0 {
+		return http.MaxBytesReader(c.Writer, c.Request.Body, limits.MaxFragmentSize), true
+	}
+	return c.Request.Body, true
+}
+
+// Writes the error response for a fragment that couldn't be saved.
+func writeSaveError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "The fragment exceeds the maximum fragment size!"})
+		return
+	}
+	if errors.Is(err, fragment.ErrFragmentExists) {
+		c.JSON(http.StatusConflict, gin.H{"message": "A fragment with this id already exists, please try again"})
+		return
+	}
+	if errors.Is(err, fragment.ErrFragmentInTrash) {
+		c.JSON(http.StatusConflict, gin.H{"message": "The fragment is in the trash, restore it before updating it"})
+		return
+	}
+	if writeConditionError(c, err) {
+		return
+	}
+	logger.Sugar.Error("Failed to save the fragment: ", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to save the fragment"})
+}
+
+// Looks up the fragment version named in the request, writing an error response when it can't be found.
+func getVersionFromRequest(c *gin.Context) (*fragment.Fragment, bool) {
+	id := c.Param("id")
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Please enter a valid version number!"})
+		return nil, false
+	}
+	frag, err := fragment.GetFragmentVersion(hashing.HashString(c.GetString("username")), id, version)
+	if err != nil {
+		logger.Sugar.Error("Failed to retrieve fragment version: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Server side error"})
+		return nil, false
+	}
+	if frag == nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Unable to find the specified version!"})
+		return nil, false
+	}
+	return frag, true
+}
+
 func main() {
 	config.Config()
+	if err := fragment.InitStores(); err != nil {
+		logger.Sugar.Fatal("Failed to initialize the fragment stores: ", err)
+	}
+	if isSubcommand("reconcile") {
+		os.Exit(runReconcileCommand(os.Args[2 : len(os.Args)-1]))
+	}
+	if isSubcommand("rotate-keys") {
+		os.Exit(runRotateKeysCommand())
+	}
+	if isSubcommand("reindex") {
+		os.Exit(runReindexCommand())
+	}
 	// Create and assign logger instance to the global variable
 
 	// UPLOADING TO DYNAMO DB
@@ -363,6 +818,9 @@ func main() {
 	// }
 	// logger.Sugar.Info(string(response))
 
+	startBackgroundReconciler()
+	startBackgroundTrashPurger()
+
 	// Start server
 	port := os.Getenv("PORT")
 	r := getRouter()
@@ -3,15 +3,22 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/fragment"
 	"github.com/Jashanpreet2/fragments/internal/testutils"
 	"github.com/Jashanpreet2/fragments/internal/utils"
+	"github.com/gohugoio/hugo/common/hashing"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,6 +29,7 @@ type FragmentMetadata struct {
 	Updated      string
 	FragmentType string
 	Size         int
+	Version      int
 }
 
 type PostFragmentResponse struct {
@@ -38,11 +46,18 @@ type GetFragmentInfoResponse struct {
 type GetFragmentsResponse struct {
 	Status       string
 	Fragment_ids []string
+	Next_cursor  string
 }
 
 type GetFragmentsExpandedResponse struct {
-	Status    string
-	Fragments []FragmentMetadata
+	Status      string
+	Fragments   []FragmentMetadata
+	Next_cursor string
+}
+
+type GetFragmentVersionsResponse struct {
+	Status   string
+	Versions []FragmentMetadata
 }
 
 func TestHealthCheck(t *testing.T) {
@@ -344,6 +359,15 @@ func TestGetConvertedFragment(t *testing.T) {
 	retrievedFileData := w.Body.Bytes()
 
 	assert.Equal(t, []byte("<h3>Hello!</h3>\n"), retrievedFileData)
+	assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+
+	getReq, _ = http.NewRequest("GET", location+"/info", nil)
+	getReq.SetBasicAuth("user1@email.com", "password1")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, getReq)
+	var info struct{ Formats []string }
+	json.Unmarshal(w.Body.Bytes(), &info)
+	assert.Equal(t, []string{"text/markdown", "text/html", "text/plain"}, info.Formats)
 }
 
 func TestGetConvertedFragmentInvalidExtension(t *testing.T) {
@@ -368,6 +392,75 @@ func TestGetConvertedFragmentInvalidExtension(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
 }
 
+func TestNegotiateFormat(t *testing.T) {
+	formats := []string{"text/markdown", "text/html"}
+	cases := []struct {
+		accept   string
+		expected string
+		ok       bool
+	}{
+		{"", "text/markdown", true},
+		{"*/*", "text/markdown", true},
+		{"text/html", "text/html", true},
+		{"text/*", "text/markdown", true},
+		{"text/markdown;q=0.5, text/html", "text/html", true},
+		{"text/*;q=0.2, text/html;q=0.9", "text/html", true},
+		{"text/html;q=0, */*", "text/markdown", true},
+		{"text/markdown;q=0, */*;q=0.1", "text/html", true},
+		{"TEXT/HTML; charset=utf-8", "text/html", true},
+		{"image/png", "", false},
+		{"text/*;q=0", "", false},
+		{"invalid, text/html;q=2", "", false},
+	}
+	for _, tc := range cases {
+		format, ok := negotiateFormat(tc.accept, formats)
+		assert.Equal(t, tc.ok, ok, tc.accept)
+		assert.Equal(t, tc.expected, format, tc.accept)
+	}
+}
+
+func TestGetFragmentAccept(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	w := testutils.PostFragment(r, []byte("### Hello!\n"), "text/markdown", "user1@email.com", "password1")
+	location := w.Header().Get("Location")
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		getReq, _ := http.NewRequest("GET", location, nil)
+		getReq.SetBasicAuth("user1@email.com", "password1")
+		if accept != "" {
+			getReq.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, getReq)
+		return w
+	}
+
+	w = get("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "### Hello!\n", w.Body.String())
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	ownETag := w.Header().Get("ETag")
+
+	w = get("text/html, */*;q=0.8")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<h3>Hello!</h3>\n", w.Body.String())
+	assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+	assert.NotEqual(t, ownETag, w.Header().Get("ETag"))
+
+	w = get("text/html;q=0.5, text/markdown")
+	assert.Equal(t, "### Hello!\n", w.Body.String())
+	assert.Equal(t, ownETag, w.Header().Get("ETag"))
+
+	w = get("image/png, application/*")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	var response struct{ Formats []string }
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, []string{"text/markdown", "text/html", "text/plain"}, response.Formats)
+}
+
 // func TestAwsAuthenticationValidAuthorization(t *testing.T) {
 // 	setup :=testutils.PreTestSetup("prod")
 // 	defer setup()
@@ -453,3 +546,770 @@ func TestPostInvalidMimetypeFragment(t *testing.T) {
 	// Assert
 	assert.Equal(t, http.StatusUnsupportedMediaType, w.Result().StatusCode)
 }
+
+func TestFragmentVersions(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+
+	w := testutils.PostFragment(r, []byte("First"), "text/plain", username, password)
+	var postFragmentResponse PostFragmentResponse
+	json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+	id := postFragmentResponse.Fragment.Id
+
+	w = testutils.PutFragment(r, id, []byte("# Second"), "text/markdown", username, password)
+	var putFragmentResponse PostFragmentResponse
+	json.Unmarshal(w.Body.Bytes(), &putFragmentResponse)
+	assert.Equal(t, 2, putFragmentResponse.Fragment.Version)
+	// Created stays the same across updates
+	assert.Equal(t, postFragmentResponse.Fragment.Created, putFragmentResponse.Fragment.Created)
+
+	req, _ := http.NewRequest("GET", "/v1/fragment/"+id+"/versions", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var versionsResponse GetFragmentVersionsResponse
+	json.Unmarshal(w.Body.Bytes(), &versionsResponse)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, len(versionsResponse.Versions))
+	assert.Equal(t, 1, versionsResponse.Versions[0].Version)
+	assert.Equal(t, "text/plain", versionsResponse.Versions[0].FragmentType)
+
+	req, _ = http.NewRequest("GET", "/v1/fragment/"+id+"/versions/1", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, []byte("First"), w.Body.Bytes())
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+
+	req, _ = http.NewRequest("POST", "/v1/fragments/"+id+"/versions/1/rollback", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", "/v1/fragment/"+id, nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, []byte("First"), w.Body.Bytes())
+
+	req, _ = http.NewRequest("GET", "/v1/fragment/"+id+"/versions/3/info", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var infoResponse GetFragmentInfoResponse
+	json.Unmarshal(w.Body.Bytes(), &infoResponse)
+	assert.Equal(t, 3, infoResponse.Fragment.Version)
+	assert.Equal(t, "text/plain", infoResponse.Fragment.FragmentType)
+}
+
+func TestGetNonExistentFragmentVersion(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	w := testutils.PostFragment(r, []byte("First"), "text/plain", "user1@email.com", "password1")
+	location := w.Header().Get("Location")
+
+	req, _ := http.NewRequest("GET", location+"/versions/5", nil)
+	req.SetBasicAuth("user1@email.com", "password1")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+type GetTrashResponse struct {
+	Status    string
+	Fragments []FragmentMetadata
+}
+
+func TestDeleteMovesFragmentToTrash(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+
+	w := testutils.PostFragment(r, []byte("Sample data"), "text/plain", username, password)
+	var postFragmentResponse PostFragmentResponse
+	json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+	id := postFragmentResponse.Fragment.Id
+
+	req, _ := http.NewRequest("DELETE", "/v1/fragments/"+id, nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	req, _ = http.NewRequest("GET", "/v1/fragment/"+id, nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = testutils.PutFragment(r, id, []byte("Updated data"), "text/plain", username, password)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	req, _ = http.NewRequest("GET", "/v1/trash", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var trashResponse GetTrashResponse
+	json.Unmarshal(w.Body.Bytes(), &trashResponse)
+	assert.Equal(t, 1, len(trashResponse.Fragments))
+	assert.Equal(t, id, trashResponse.Fragments[0].Id)
+
+	req, _ = http.NewRequest("POST", "/v1/trash/"+id+"/restore", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", "/v1/fragment/"+id, nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, []byte("Sample data"), w.Body.Bytes())
+}
+
+func TestPurgeTrashedFragment(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+
+	w := testutils.PostFragment(r, []byte("Sample data"), "text/plain", username, password)
+	var postFragmentResponse PostFragmentResponse
+	json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+	id := postFragmentResponse.Fragment.Id
+
+	// Only fragments in the trash can be purged
+	req, _ := http.NewRequest("DELETE", "/v1/trash/"+id, nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req, _ = http.NewRequest("DELETE", "/v1/fragments/"+id, nil)
+	req.SetBasicAuth(username, password)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("DELETE", "/v1/trash/"+id, nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("POST", "/v1/trash/"+id+"/restore", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+type GetUsageResponse struct {
+	Status string
+	Usage  struct {
+		Fragments int
+		Bytes     int64
+	}
+}
+
+func TestFragmentSizeLimits(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+	maxFragmentSize, quotaBytes := config.MaxFragmentSize, config.QuotaBytes
+	defer func() { config.MaxFragmentSize, config.QuotaBytes = maxFragmentSize, quotaBytes }()
+	config.MaxFragmentSize = 10
+	config.QuotaBytes = 15
+
+	r := getRouter()
+	w := testutils.PostFragment(r, []byte("More than ten bytes"), "text/plain", "user1@email.com", "password1")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = testutils.PostFragment(r, []byte("Ten bytes!"), "text/plain", "user1@email.com", "password1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = testutils.PostFragment(r, []byte("Ten bytes!"), "text/plain", "user1@email.com", "password1")
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+
+	req, _ := http.NewRequest("GET", "/v1/usage", nil)
+	req.SetBasicAuth("user1@email.com", "password1")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var usageResponse GetUsageResponse
+	json.Unmarshal(w.Body.Bytes(), &usageResponse)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, usageResponse.Usage.Fragments)
+	assert.Equal(t, int64(10), usageResponse.Usage.Bytes)
+}
+
+func TestPostFragmentWithoutContentLength(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+	maxFragmentSize := config.MaxFragmentSize
+	defer func() { config.MaxFragmentSize = maxFragmentSize }()
+	config.MaxFragmentSize = 10
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+
+	// A body of unknown length is streamed until it passes the maximum fragment size
+	req, _ := http.NewRequest("POST", "/v1/fragments", io.MultiReader(bytes.NewReader([]byte("More than ")), bytes.NewReader([]byte("ten bytes"))))
+	req.ContentLength = -1
+	req.Header.Add("Content-Type", "text/plain")
+	req.SetBasicAuth(username, password)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	req, _ = http.NewRequest("POST", "/v1/fragments", io.MultiReader(bytes.NewReader([]byte("Ten ")), bytes.NewReader([]byte("bytes!"))))
+	req.ContentLength = -1
+	req.Header.Add("Content-Type", "text/plain")
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var postFragmentResponse PostFragmentResponse
+	json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 10, postFragmentResponse.Fragment.Size)
+
+	req, _ = http.NewRequest("GET", w.Header().Get("Location"), nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, []byte("Ten bytes!"), w.Body.Bytes())
+	assert.Equal(t, "10", w.Header().Get("Content-Length"))
+}
+
+func TestParseRange(t *testing.T) {
+	ranges, err := parseRange("bytes=0-4", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 5}}, ranges)
+
+	ranges, _ = parseRange("bytes=5-", 10)
+	assert.Equal(t, []byteRange{{5, 5}}, ranges)
+
+	ranges, _ = parseRange("bytes=-3", 10)
+	assert.Equal(t, []byteRange{{7, 3}}, ranges)
+
+	// The end is clamped to the size
+	ranges, _ = parseRange("bytes=8-100, 0-0", 10)
+	assert.Equal(t, []byteRange{{8, 2}, {0, 1}}, ranges)
+
+	// Malformed headers are ignored
+	ranges, err = parseRange("bytes=4-2", 10)
+	assert.NoError(t, err)
+	assert.Nil(t, ranges)
+	ranges, _ = parseRange("lines=1-2", 10)
+	assert.Nil(t, ranges)
+
+	_, err = parseRange("bytes=10-", 10)
+	assert.ErrorIs(t, err, errUnsatisfiableRange)
+}
+
+func TestGetFragmentRange(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+	w := testutils.PostFragment(r, []byte("0123456789"), "text/plain", username, password)
+	location := w.Header().Get("Location")
+
+	req, _ := http.NewRequest("GET", location, nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+
+	req, _ = http.NewRequest("GET", location, nil)
+	req.SetBasicAuth(username, password)
+	req.Header.Set("Range", "bytes=2-4")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "bytes 2-4/10", w.Header().Get("Content-Range"))
+	assert.Equal(t, "3", w.Header().Get("Content-Length"))
+	assert.Equal(t, []byte("234"), w.Body.Bytes())
+
+	req, _ = http.NewRequest("GET", location, nil)
+	req.SetBasicAuth(username, password)
+	req.Header.Set("Range", "bytes=20-")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	assert.Equal(t, "bytes */10", w.Header().Get("Content-Range"))
+}
+
+func TestGetFragmentMultipleRanges(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+	w := testutils.PostFragment(r, []byte("0123456789"), "text/plain", username, password)
+
+	req, _ := http.NewRequest("GET", w.Header().Get("Location"), nil)
+	req.SetBasicAuth(username, password)
+	req.Header.Set("Range", "bytes=0-1,-2")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	expected := []struct{ contentRange, data string }{{"bytes 0-1/10", "01"}, {"bytes 8-9/10", "89"}}
+	for _, part := range expected {
+		p, err := reader.NextPart()
+		if !assert.NoError(t, err) {
+			return
+		}
+		data, _ := io.ReadAll(p)
+		assert.Equal(t, part.contentRange, p.Header.Get("Content-Range"))
+		assert.Equal(t, "text/plain", p.Header.Get("Content-Type"))
+		assert.Equal(t, part.data, string(data))
+	}
+	_, err = reader.NextPart()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestConditionalGet(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+	w := testutils.PostFragment(r, []byte("Sample data"), "text/plain", username, password)
+	location := w.Header().Get("Location")
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	for _, path := range []string{location, location + "/info"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.SetBasicAuth(username, password)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		// The info has its own validator that also covers the metadata
+		pathETag := w.Header().Get("ETag")
+		if path == location {
+			assert.Equal(t, etag, pathETag)
+		} else {
+			assert.NotEqual(t, etag, pathETag)
+		}
+		lastModified := w.Header().Get("Last-Modified")
+		assert.NotEmpty(t, lastModified)
+
+		req, _ = http.NewRequest("GET", path, nil)
+		req.SetBasicAuth(username, password)
+		req.Header.Set("If-None-Match", `"other", `+pathETag)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.Bytes())
+
+		req, _ = http.NewRequest("GET", path, nil)
+		req.SetBasicAuth(username, password)
+		req.Header.Set("If-Modified-Since", lastModified)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+
+		req, _ = http.NewRequest("GET", path, nil)
+		req.SetBasicAuth(username, password)
+		req.Header.Set("If-None-Match", `"other"`)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func TestConditionalUpdate(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+	w := testutils.PostFragment(r, []byte("Sample data"), "text/plain", username, password)
+	var postFragmentResponse PostFragmentResponse
+	json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+	id := postFragmentResponse.Fragment.Id
+	etag := w.Header().Get("ETag")
+
+	put := func(ifMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/v1/fragments/"+id, bytes.NewReader([]byte("Updated data")))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("If-Match", ifMatch)
+		req.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w = put(etag)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// The fragment changed since the first ETag was handed out
+	w = put(etag)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	req, _ := http.NewRequest("DELETE", "/v1/fragments/"+id, nil)
+	req.Header.Set("If-Match", etag)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	req, _ = http.NewRequest("DELETE", "/v1/fragments/"+id, nil)
+	req.Header.Set("If-Match", "*")
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestListFragmentsPagination(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+	ids := []string{}
+	for _, data := range []string{"a", "abc", "ab"} {
+		w := testutils.PostFragment(r, []byte(data), "text/plain", username, password)
+		var postFragmentResponse PostFragmentResponse
+		json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+		ids = append(ids, postFragmentResponse.Fragment.Id)
+	}
+	testutils.PostFragment(r, []byte("# Title"), "text/markdown", username, password)
+
+	list := func(query string) (*httptest.ResponseRecorder, GetFragmentsResponse) {
+		req, _ := http.NewRequest("GET", "/v1/fragments?"+query, nil)
+		req.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var response GetFragmentsResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	w, response := list("type=text/plain&sort=-size&limit=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{ids[1], ids[2]}, response.Fragment_ids)
+	assert.NotEqual(t, "", response.Next_cursor)
+
+	_, response = list("type=text/plain&sort=-size&limit=2&cursor=" + response.Next_cursor)
+	assert.Equal(t, []string{ids[0]}, response.Fragment_ids)
+	assert.Equal(t, "", response.Next_cursor)
+
+	_, response = list("min_size=2&max_size=3")
+	assert.Equal(t, 2, len(response.Fragment_ids))
+
+	req, _ := http.NewRequest("GET", "/v1/fragments?expand=1&type=text/markdown", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var expanded GetFragmentsExpandedResponse
+	json.Unmarshal(w.Body.Bytes(), &expanded)
+	assert.Equal(t, 1, len(expanded.Fragments))
+	assert.Equal(t, "text/markdown", expanded.Fragments[0].FragmentType)
+
+	for _, query := range []string{"limit=0", "sort=name", "created_after=yesterday", "min_size=-1", "sort=size&cursor=abc"} {
+		w, _ = list(query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+// Fails to read one fragment of every batch
+type failingBatchStore struct {
+	*fragment.MemoryStore
+	failId string
+}
+
+func (store *failingBatchStore) BatchGetFragments(ownerId string, ids []string) []fragment.FragmentResult {
+	results := store.MemoryStore.BatchGetFragments(ownerId, ids)
+	for i, id := range ids {
+		if id == store.failId {
+			results[i] = fragment.FragmentResult{Err: errors.New("injected failure")}
+		}
+	}
+	return results
+}
+
+func TestGetFragmentsExpandedReportsErrors(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+	ids := []string{}
+	for _, data := range []string{"a", "b"} {
+		w := testutils.PostFragment(r, []byte(data), "text/plain", username, password)
+		var postFragmentResponse PostFragmentResponse
+		json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+		ids = append(ids, postFragmentResponse.Fragment.Id)
+	}
+
+	memory := fragment.NewMemoryStore()
+	for _, id := range ids {
+		frag, _ := fragment.GetFragment(hashing.HashString(username), id)
+		memory.WriteFragment(frag)
+	}
+	fragment.SetStores(&failingBatchStore{memory, ids[1]}, memory)
+
+	req, _ := http.NewRequest("GET", "/v1/fragments?expand=1", nil)
+	req.SetBasicAuth(username, password)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var response struct {
+		Fragments []FragmentMetadata
+		Errors    []struct {
+			Id      string
+			Message string
+		}
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, len(response.Fragments))
+	assert.Equal(t, ids[0], response.Fragments[0].Id)
+	assert.Equal(t, 1, len(response.Errors))
+	assert.Equal(t, ids[1], response.Errors[0].Id)
+}
+
+func TestSearchFragments(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+	w := testutils.PostFragment(r, []byte("The quick brown fox"), "text/plain", username, password)
+	var postFragmentResponse PostFragmentResponse
+	json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+	testutils.PostFragment(r, []byte("Nothing to see here"), "text/plain", username, password)
+	testutils.PostFragment(r, []byte("Another fox"), "text/plain", "user2@email.com", "password2")
+
+	req, _ := http.NewRequest("GET", "/v1/fragments/search?q=fox", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var response struct {
+		Status  string
+		Results []struct {
+			Id      string
+			Snippet string
+		}
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, len(response.Results))
+	assert.Equal(t, postFragmentResponse.Fragment.Id, response.Results[0].Id)
+	assert.Equal(t, "The quick brown fox", response.Results[0].Snippet)
+
+	req, _ = http.NewRequest("GET", "/v1/fragments/search", nil)
+	req.SetBasicAuth(username, password)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestFragmentTagsAndMetadata(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+	send := func(method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		req.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	var response struct {
+		Fragment fragment.Fragment
+	}
+
+	w := send("POST", "/v1/fragments", "Sample data", map[string]string{"X-Fragment-Tag": "Draft, work", "X-Fragment-Meta-Project": "fragments"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	response.Fragment = fragment.Fragment{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	id := response.Fragment.Id
+	assert.Equal(t, []string{"draft", "work"}, response.Fragment.Tags)
+	send("POST", "/v1/fragments", "Other data", nil)
+
+	w = send("GET", "/v1/fragment/"+id+"/info", "", nil)
+	response.Fragment = fragment.Fragment{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, map[string]string{"project": "fragments"}, response.Fragment.Metadata)
+	infoETag := w.Header().Get("ETag")
+	updated := response.Fragment.Updated
+
+	w = send("PATCH", "/v1/fragments/"+id+"/metadata", `{"tags": ["final"], "metadata": {"project": null, "status": "done"}}`, map[string]string{"If-Match": infoETag})
+	assert.Equal(t, http.StatusOK, w.Code)
+	response.Fragment = fragment.Fragment{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, []string{"final"}, response.Fragment.Tags)
+	assert.Equal(t, map[string]string{"status": "done"}, response.Fragment.Metadata)
+	assert.True(t, response.Fragment.Updated.After(updated))
+	patchedETag := w.Header().Get("ETag")
+	assert.NotEqual(t, infoETag, patchedETag)
+
+	// Cached metadata is stale and concurrent edits are detected
+	assert.Equal(t, http.StatusOK, send("GET", "/v1/fragment/"+id+"/info", "", map[string]string{"If-None-Match": infoETag}).Code)
+	assert.Equal(t, http.StatusNotModified, send("GET", "/v1/fragment/"+id+"/info", "", map[string]string{"If-None-Match": patchedETag}).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, send("PATCH", "/v1/fragments/"+id+"/metadata", `{"tags": []}`, map[string]string{"If-Match": infoETag}).Code)
+
+	w = send("GET", "/v1/fragments?tag=final&expand=1", "", nil)
+	var listing struct {
+		Fragments []fragment.Fragment
+	}
+	json.Unmarshal(w.Body.Bytes(), &listing)
+	assert.Equal(t, 1, len(listing.Fragments))
+	assert.Equal(t, id, listing.Fragments[0].Id)
+	assert.Equal(t, []string{"final"}, listing.Fragments[0].Tags)
+
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/v1/fragments", "Sample data", map[string]string{"X-Fragment-Tag": "a,,b"}).Code)
+	assert.Equal(t, http.StatusBadRequest, send("PATCH", "/v1/fragments/"+id+"/metadata", `{"metadata": {"bad key": "value"}}`, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, send("PATCH", "/v1/fragments/"+id+"/metadata", `not json`, nil).Code)
+	assert.Equal(t, http.StatusNotFound, send("PATCH", "/v1/fragments/missing/metadata", `{"tags": []}`, nil).Code)
+}
+
+func TestCollectionsAndPaths(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+
+	r := getRouter()
+	username := "user1@email.com"
+	password := "password1"
+	send := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.SetBasicAuth(username, password)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	type collectionResponse struct {
+		Collection  fragment.Collection
+		Collections []fragment.Collection
+		Fragments   []fragment.Fragment
+	}
+
+	w := send("POST", "/v1/collections", `{"name": "work"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var work collectionResponse
+	json.Unmarshal(w.Body.Bytes(), &work)
+	assert.Equal(t, "/work", work.Collection.Path)
+	assert.Equal(t, http.StatusConflict, send("POST", "/v1/collections", `{"name": "work"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/v1/collections", `{"name": "a/b"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send("POST", "/v1/collections", `{"name": "notes", "parentId": "missing"}`).Code)
+
+	w = testutils.PostFragment(r, []byte("Sample data"), "text/plain", username, password)
+	var postFragmentResponse PostFragmentResponse
+	json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+	id := postFragmentResponse.Fragment.Id
+	w = send("PATCH", "/v1/fragments/"+id+"/metadata", `{"name": "todo", "collectionId": "`+work.Collection.Id+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send("GET", "/v1/path/work/todo", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Sample data", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, send("GET", "/v1/path/work", "").Code)
+
+	w = send("GET", "/v1/collections/"+work.Collection.Id, "")
+	var listing collectionResponse
+	json.Unmarshal(w.Body.Bytes(), &listing)
+	assert.Equal(t, 1, len(listing.Fragments))
+	assert.Equal(t, "todo", listing.Fragments[0].Name)
+
+	w = send("PATCH", "/v1/collections/"+work.Collection.Id, `{"name": "projects"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, send("GET", "/v1/path/projects/todo", "").Code)
+	assert.Equal(t, http.StatusNotFound, send("GET", "/v1/path/work/todo", "").Code)
+	assert.Equal(t, http.StatusBadRequest, send("PATCH", "/v1/collections/"+work.Collection.Id, `{"parentId": "`+work.Collection.Id+`"}`).Code)
+
+	// Conditional requests don't return what the path or the name used to be
+	getIfNoneMatch := func(path string, etag string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.SetBasicAuth(username, password)
+		req.Header.Set("If-None-Match", etag)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	w = send("GET", "/v1/path/projects/todo", "")
+	pathETag := w.Header().Get("ETag")
+	assert.Empty(t, w.Header().Get("Last-Modified"))
+	assert.Equal(t, http.StatusNotModified, getIfNoneMatch("/v1/path/projects/todo", pathETag).Code)
+	infoETag := send("GET", "/v1/fragment/"+id+"/info", "").Header().Get("ETag")
+	assert.Equal(t, http.StatusOK, send("PATCH", "/v1/fragments/"+id+"/metadata", `{"name": "done"}`).Code)
+	assert.Equal(t, http.StatusOK, getIfNoneMatch("/v1/fragment/"+id+"/info", infoETag).Code)
+	// Another fragment with the same data takes the path
+	w = testutils.PostFragment(r, []byte("Sample data"), "text/plain", username, password)
+	json.Unmarshal(w.Body.Bytes(), &postFragmentResponse)
+	otherId := postFragmentResponse.Fragment.Id
+	assert.Equal(t, http.StatusOK, send("PATCH", "/v1/fragments/"+otherId+"/metadata", `{"name": "todo", "collectionId": "`+work.Collection.Id+`"}`).Code)
+	w = getIfNoneMatch("/v1/path/projects/todo", pathETag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, pathETag, w.Header().Get("ETag"))
+	assert.Equal(t, http.StatusOK, send("PATCH", "/v1/fragments/"+otherId+"/metadata", `{"name": ""}`).Code)
+	assert.Equal(t, http.StatusOK, send("PATCH", "/v1/fragments/"+id+"/metadata", `{"name": "todo"}`).Code)
+
+	w = send("GET", "/v1/collections", "")
+	var all collectionResponse
+	json.Unmarshal(w.Body.Bytes(), &all)
+	assert.Equal(t, 1, len(all.Collections))
+	assert.Equal(t, "/projects", all.Collections[0].Path)
+
+	assert.Equal(t, http.StatusConflict, send("DELETE", "/v1/collections/"+work.Collection.Id, "").Code)
+	send("PATCH", "/v1/fragments/"+id+"/metadata", `{"name": ""}`)
+	assert.Equal(t, http.StatusOK, send("DELETE", "/v1/collections/"+work.Collection.Id, "").Code)
+	assert.Equal(t, http.StatusNotFound, send("GET", "/v1/collections/"+work.Collection.Id, "").Code)
+}
+
+type fixedIDGenerator struct{}
+
+func (fixedIDGenerator) NewID() string {
+	return "fixed"
+}
+
+func TestPostFragmentIdCollision(t *testing.T) {
+	setup := testutils.PreTestSetup("debug")
+	defer setup()
+	fragment.SetIDGenerator(fixedIDGenerator{})
+	defer fragment.SetIDGenerator(nil)
+
+	r := getRouter()
+	w := testutils.PostFragment(r, []byte("Sample data"), "text/plain", "user1@email.com", "password1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = testutils.PostFragment(r, []byte("Other data"), "text/plain", "user1@email.com", "password1")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	frag, _ := fragment.GetFragment(hashing.HashString("user1@email.com"), "fixed")
+	data, _ := frag.GetData()
+	assert.Equal(t, "Sample data", string(data))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tagHeader            = "X-Fragment-Tag"
+	metadataHeaderPrefix = "X-Fragment-Meta-"
+)
+
+// Reads the tags and custom metadata sent with a POST or PUT. Tags are sent in
+// X-Fragment-Tag headers, which can be repeated and hold comma separated tags,
+// and each metadata entry in an X-Fragment-Meta-<key> header.
+//
+// Either result is nil when the request has no headers for it, so that a PUT
+// keeps the fragment's current tags or metadata. An empty X-Fragment-Tag
+// header removes every tag.
+func parseMetadataHeaders(c *gin.Context) ([]string, map[string]string, error) {
+	var tags []string
+	var metadata map[string]string
+	for name, values := range c.Request.Header {
+		if name == tagHeader {
+			if tags == nil {
+				tags = []string{}
+			}
+			for _, value := range values {
+				if strings.TrimSpace(value) != "" {
+					tags = append(tags, strings.Split(value, ",")...)
+				}
+			}
+		} else if key, ok := strings.CutPrefix(name, metadataHeaderPrefix); ok {
+			if metadata == nil {
+				metadata = map[string]string{}
+			}
+			metadata[key] = strings.Join(values, ", ")
+		}
+	}
+
+	var err error
+	if tags != nil {
+		if tags, err = fragment.NormalizeTags(tags); err != nil {
+			return nil, nil, err
+		}
+	}
+	if metadata != nil {
+		if metadata, err = fragment.NormalizeMetadata(metadata); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tags, metadata, nil
+}
+
+// Reads the tag filters of GET /fragments, given as repeated tag parameters.
+func tagQuery(c *gin.Context) ([]string, error) {
+	tags := c.QueryArray("tag")
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	return fragment.NormalizeTags(tags)
+}
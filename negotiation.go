@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A media range of an Accept header, e.g. text/* with its q-value
+type mediaRange struct {
+	mimeType string
+	quality  float64
+}
+
+// Parses an Accept header. Ranges that are malformed or whose q-value is
+// invalid are skipped.
+func parseAccept(header string) []mediaRange {
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mimeType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mainType, subType, ok := strings.Cut(mimeType, "/"); !ok || mainType == "" || subType == "" || mainType == "*" && subType != "*" {
+			continue
+		}
+		quality := 1.0
+		valid := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				valid = err == nil && parsed >= 0 && parsed <= 1
+				quality = parsed
+			}
+		}
+		if valid {
+			ranges = append(ranges, mediaRange{mimeType, quality})
+		}
+	}
+	return ranges
+}
+
+// Returns how specifically the range matches the type: 3 for the exact type,
+// 2 for type/*, 1 for */* and 0 when it doesn't match.
+func (r mediaRange) specificity(mimeType string) int {
+	switch {
+	case r.mimeType == mimeType:
+		return 3
+	case r.mimeType == "*/*":
+		return 1
+	case strings.HasSuffix(r.mimeType, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(r.mimeType, "*")):
+		return 2
+	}
+	return 0
+}
+
+// Returns the q-value the ranges give the type, taken from the most specific
+// range that matches it. Parameters other than q are ignored.
+func acceptQuality(ranges []mediaRange, mimeType string) float64 {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	best, quality := 0, 0.0
+	for _, r := range ranges {
+		if specificity := r.specificity(mimeType); specificity > best {
+			best, quality = specificity, r.quality
+		}
+	}
+	return quality
+}
+
+// Picks the format the Accept header prefers out of the available formats.
+// Formats that are equally acceptable are picked in the order they are
+// given, so the fragment's own type should come first. Returns false when the
+// header rules out every format. Without an Accept header the first format is
+// picked.
+func negotiateFormat(header string, formats []string) (string, bool) {
+	if len(formats) == 0 {
+		return "", false
+	}
+	if strings.TrimSpace(header) == "" {
+		return formats[0], true
+	}
+	ranges := parseAccept(header)
+	best, bestQuality := "", 0.0
+	for _, format := range formats {
+		if quality := acceptQuality(ranges, format); quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	return best, best != ""
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/logger"
+	"github.com/gin-gonic/gin"
+)
+
+// Requests asking for more ranges than this are answered with the whole fragment
+const maxRanges = 20
+
+var errUnsatisfiableRange = errors.New("no range is satisfiable")
+
+// A range of bytes within a fragment
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// Parses a Range header for content of the given size as described in RFC 9110.
+// Returns nil, nil when the header is missing, malformed or asks for too many
+// ranges, in which case the whole content should be sent. Ranges that lie
+// outside of the content are dropped and errUnsatisfiableRange is returned
+// when none are left.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, nil
+	}
+	specs := strings.Split(spec, ",")
+	if len(specs) > maxRanges {
+		return nil, nil
+	}
+
+	ranges := []byteRange{}
+	for _, spec := range specs {
+		first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+		if !ok {
+			return nil, nil
+		}
+		var r byteRange
+		if first == "" {
+			// Suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = byteRange{size - n, n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, nil
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start, min(end, size-1) - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// Streams the fragment data to the response, honoring the request's Range header.
+func writeFragmentData(c *gin.Context, frag *fragment.Fragment) {
+	size := int64(frag.Size)
+	c.Header("Accept-Ranges", "bytes")
+	ranges, err := parseRange(c.GetHeader("Range"), size)
+	if errors.Is(err, errUnsatisfiableRange) {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"message": "The requested range is not satisfiable!"})
+		return
+	}
+
+	switch len(ranges) {
+	case 0:
+		reader, err := frag.OpenData()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to find the fragment data"})
+			return
+		}
+		defer reader.Close()
+		c.DataFromReader(http.StatusOK, size, frag.MimeType(), reader, nil)
+	case 1:
+		reader, err := frag.OpenDataRange(ranges[0].start, ranges[0].length)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to find the fragment data"})
+			return
+		}
+		defer reader.Close()
+		c.DataFromReader(http.StatusPartialContent, ranges[0].length, frag.MimeType(),
+			reader, map[string]string{"Content-Range": ranges[0].contentRange(size)})
+	default:
+		writeMultipartRanges(c, frag, ranges)
+	}
+}
+
+// Sends each range as a part of a multipart/byteranges response. Every range
+// is read separately so only the requested bytes are fetched.
+func writeMultipartRanges(c *gin.Context, frag *fragment.Fragment, ranges []byteRange) {
+	// Open the first range before anything is sent so that a missing blob can
+	// still be reported with an error status
+	first, err := frag.OpenDataRange(ranges[0].start, ranges[0].length)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Failed to find the fragment data"})
+		return
+	}
+
+	parts := multipart.NewWriter(c.Writer)
+	c.Header("Content-Type", "multipart/byteranges; boundary="+parts.Boundary())
+	c.Status(http.StatusPartialContent)
+	for i, r := range ranges {
+		reader := first
+		if i > 0 {
+			if reader, err = frag.OpenDataRange(r.start, r.length); err != nil {
+				// The status was already sent, the truncated body tells the client that something went wrong
+				logger.Sugar.Error("Failed to read a range of the fragment: ", err)
+				return
+			}
+		}
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {frag.MimeType()},
+			"Content-Range": {r.contentRange(int64(frag.Size))},
+		})
+		if err == nil {
+			_, err = io.Copy(part, reader)
+		}
+		reader.Close()
+		if err != nil {
+			logger.Sugar.Error("Failed to write a range of the fragment: ", err)
+			return
+		}
+	}
+	parts.Close()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+// Checks the metadata and data stores for drift and prints the report as JSON.
+//
+// Usage: fragments reconcile [-dry-run=false] [-owners=id1,id2] [-min-orphan-age=1h] debug|prod
+func runReconcileCommand(args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", true, "only report discrepancies without repairing them")
+	owners := flags.String("owners", "", "comma separated owner ids to check, all owners when empty")
+	minOrphanAge := flags.Duration("min-orphan-age", config.ReconcileMinOrphanAge, "ignore blobs younger than this when looking for orphans")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	options := fragment.ReconcileOptions{DryRun: *dryRun, MinOrphanAge: *minOrphanAge}
+	if *owners != "" {
+		options.OwnerIds = strings.Split(*owners, ",")
+	}
+
+	report, err := fragment.Reconcile(options)
+	if report != nil {
+		output, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(output))
+	}
+	if err != nil {
+		logger.Sugar.Error("Reconciliation failed: ", err)
+		return 1
+	}
+	return 0
+}
+
+// Starts the periodic reconciliation when an interval is configured.
+func startBackgroundReconciler() {
+	if config.ReconcileInterval <= 0 {
+		return
+	}
+	logger.Sugar.Infof("Reconciling fragment storage every %s (dry run: %t)", config.ReconcileInterval, config.ReconcileDryRun)
+	fragment.StartReconciler(config.ReconcileInterval, fragment.ReconcileOptions{
+		DryRun:       config.ReconcileDryRun,
+		MinOrphanAge: config.ReconcileMinOrphanAge,
+	})
+}
+
+func isSubcommand(name string) bool {
+	return len(os.Args) > 2 && os.Args[1] == name
+}
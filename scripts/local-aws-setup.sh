@@ -32,18 +32,96 @@ aws --endpoint-url=http://localhost:4566 s3api create-bucket --bucket fragments
 # Setup DynamoDB Table with dynamodb-local, see:
 # https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/getting-started-step-1.html
 echo "Creating DynamoDB-Local DynamoDB table: fragments"
+# Listings are read from one index per sort field, see FragmentsDynamoDBClient.ListFragments
 aws --endpoint-url=http://localhost:8000 \
 dynamodb create-table \
     --table-name fragments \
     --attribute-definitions \
         AttributeName=ownerId,AttributeType=S \
         AttributeName=id,AttributeType=S \
+        AttributeName=createdNanos,AttributeType=N \
+        AttributeName=updatedNanos,AttributeType=N \
+        AttributeName=size,AttributeType=N \
     --key-schema \
         AttributeName=ownerId,KeyType=HASH \
         AttributeName=id,KeyType=RANGE \
+    --global-secondary-indexes \
+        '[
+            {"IndexName": "ownerId-created-index",
+             "KeySchema": [{"AttributeName": "ownerId", "KeyType": "HASH"}, {"AttributeName": "createdNanos", "KeyType": "RANGE"}],
+             "Projection": {"ProjectionType": "ALL"},
+             "ProvisionedThroughput": {"ReadCapacityUnits": 10, "WriteCapacityUnits": 5}},
+            {"IndexName": "ownerId-updated-index",
+             "KeySchema": [{"AttributeName": "ownerId", "KeyType": "HASH"}, {"AttributeName": "updatedNanos", "KeyType": "RANGE"}],
+             "Projection": {"ProjectionType": "ALL"},
+             "ProvisionedThroughput": {"ReadCapacityUnits": 10, "WriteCapacityUnits": 5}},
+            {"IndexName": "ownerId-size-index",
+             "KeySchema": [{"AttributeName": "ownerId", "KeyType": "HASH"}, {"AttributeName": "size", "KeyType": "RANGE"}],
+             "Projection": {"ProjectionType": "ALL"},
+             "ProvisionedThroughput": {"ReadCapacityUnits": 10, "WriteCapacityUnits": 5}}
+        ]' \
     --provisioned-throughput \
         ReadCapacityUnits=10,WriteCapacityUnits=5
 
 # Wait until the Fragments table exists in dynamodb-local, so we can use it, see:
 # https://awscli.amazonaws.com/v2/documentation/api/latest/reference/dynamodb/wait/table-exists.html
-aws --endpoint-url=http://localhost:8000 dynamodb wait table-exists --table-name fragments
\ No newline at end of file
+aws --endpoint-url=http://localhost:8000 dynamodb wait table-exists --table-name fragments
+echo "Creating DynamoDB-Local DynamoDB table: fragment-versions"
+aws --endpoint-url=http://localhost:8000 \
+dynamodb create-table \
+    --table-name fragment-versions \
+    --attribute-definitions \
+        AttributeName=fragmentKey,AttributeType=S \
+        AttributeName=version,AttributeType=N \
+    --key-schema \
+        AttributeName=fragmentKey,KeyType=HASH \
+        AttributeName=version,KeyType=RANGE \
+    --provisioned-throughput \
+        ReadCapacityUnits=10,WriteCapacityUnits=5
+
+aws --endpoint-url=http://localhost:8000 dynamodb wait table-exists --table-name fragment-versions
+
+echo "Creating DynamoDB-Local DynamoDB table: fragment-blobs"
+aws --endpoint-url=http://localhost:8000 \
+dynamodb create-table \
+    --table-name fragment-blobs \
+    --attribute-definitions \
+        AttributeName=ownerId,AttributeType=S \
+        AttributeName=dataKey,AttributeType=S \
+    --key-schema \
+        AttributeName=ownerId,KeyType=HASH \
+        AttributeName=dataKey,KeyType=RANGE \
+    --provisioned-throughput \
+        ReadCapacityUnits=10,WriteCapacityUnits=5
+
+aws --endpoint-url=http://localhost:8000 dynamodb wait table-exists --table-name fragment-blobs
+
+echo "Creating DynamoDB-Local DynamoDB table: fragment-keys"
+aws --endpoint-url=http://localhost:8000 \
+dynamodb create-table \
+    --table-name fragment-keys \
+    --attribute-definitions \
+        AttributeName=ownerId,AttributeType=S \
+        AttributeName=id,AttributeType=S \
+    --key-schema \
+        AttributeName=ownerId,KeyType=HASH \
+        AttributeName=id,KeyType=RANGE \
+    --provisioned-throughput \
+        ReadCapacityUnits=10,WriteCapacityUnits=5
+
+aws --endpoint-url=http://localhost:8000 dynamodb wait table-exists --table-name fragment-keys
+
+echo "Creating DynamoDB-Local DynamoDB table: fragment-names"
+aws --endpoint-url=http://localhost:8000 \
+dynamodb create-table \
+    --table-name fragment-names \
+    --attribute-definitions \
+        AttributeName=ownerId,AttributeType=S \
+        AttributeName=entryKey,AttributeType=S \
+    --key-schema \
+        AttributeName=ownerId,KeyType=HASH \
+        AttributeName=entryKey,KeyType=RANGE \
+    --provisioned-throughput \
+        ReadCapacityUnits=10,WriteCapacityUnits=5
+
+aws --endpoint-url=http://localhost:8000 dynamodb wait table-exists --table-name fragment-names
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+// Number of results returned by a search when no limit is given, and the largest limit allowed
+const defaultSearchLimit = 20
+const maxSearchLimit = 100
+
+// Rebuilds the local search index from the fragments in the stores, e.g. for
+// data written before search existed or by other server instances.
+//
+// Usage: fragments reindex debug|prod
+func runReindexCommand() int {
+	indexed, err := fragment.RebuildSearchIndex()
+	fmt.Printf("Indexed %d fragments\n", indexed)
+	if err != nil {
+		logger.Sugar.Error("Rebuilding the search index failed: ", err)
+		return 1
+	}
+	return 0
+}
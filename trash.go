@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/Jashanpreet2/fragments/internal/config"
+	"github.com/Jashanpreet2/fragments/internal/fragment"
+	"github.com/Jashanpreet2/fragments/internal/logger"
+)
+
+// Starts purging expired fragments from the trash when an interval is configured.
+func startBackgroundTrashPurger() {
+	if config.TrashPurgeInterval <= 0 {
+		return
+	}
+	logger.Sugar.Infof("Purging fragments older than %s from the trash every %s", config.TrashRetention, config.TrashPurgeInterval)
+	fragment.StartTrashPurger(config.TrashPurgeInterval, config.TrashRetention)
+}